@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// accountVersion is the current format of the value stored in the keyring.
+// Version 0 is the legacy format, where the value is the bare Base32 secret.
+const accountVersion = 1
+
+const (
+	accountTypeTOTP = "totp"
+	accountTypeHOTP = "hotp"
+)
+
+// account is the JSON value stored in the keyring for each name.
+type account struct {
+	Version   int      `json:"version"`
+	Type      string   `json:"type,omitempty"` // accountTypeTOTP when empty
+	Counter   uint64   `json:"counter,omitempty"`
+	Secret    string   `json:"secret"`
+	Algorithm string   `json:"algorithm"`
+	Digits    int      `json:"digits"`
+	Period    int      `json:"period"`
+	Issuer    string   `json:"issuer,omitempty"`
+	Account   string   `json:"account,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+
+	// T0 is the Unix time TOTP steps are counted from (RFC 6238). Almost
+	// every service uses the epoch, 0.
+	T0 int64 `json:"t0,omitempty"`
+
+	// TimeSource, if set, is the clock codes are computed against instead
+	// of the local one: an NTP server or an http(s) URL (see accountTime).
+	TimeSource string `json:"time_source,omitempty"`
+
+	// Backups are additional secrets some services issue for the same
+	// account. They share the primary secret's parameters.
+	Backups []string `json:"backups,omitempty"`
+
+	// Pending is the new secret of a rotation in progress (totp rotate). Its
+	// codes are shown next to those of Secret until the rotation is
+	// confirmed. It shares the entry's parameters.
+	Pending *pendingRotation `json:"pending,omitempty"`
+
+	// Source records the image the entry was scanned from, with
+	// scan --record-source.
+	Source *scanOrigin `json:"source,omitempty"`
+
+	// Protected holds the secret encrypted under a passphrase. Secret is
+	// never stored for protected entries; it is only filled in memory once
+	// unlocked.
+	Protected *sealedSecret `json:"protected,omitempty"`
+}
+
+// scanOrigin identifies the image an entry was scanned from: its absolute
+// path or URL, and the SHA-256 of its contents to tell whether a file at that
+// path is still the same image.
+type scanOrigin struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+}
+
+// newAccount returns a current-version account with the default parameters.
+func newAccount(secret string) account {
+	return account{
+		Version:   accountVersion,
+		Secret:    secret,
+		Algorithm: defaultAlgorithm,
+		Digits:    defaultDigits,
+		Period:    defaultPeriod,
+	}
+}
+
+// checkParams validates TOTP parameters given on the command line or in an
+// otpauth URL.
+func checkParams(algorithm string, digits, period int) error {
+	if _, err := hashFunc(algorithm); err != nil {
+		return err
+	}
+	if digits < 1 || digits > 10 {
+		return fmt.Errorf("invalid digits: %v (expected 1-10)", digits)
+	}
+	if period < 1 {
+		return fmt.Errorf("invalid period: %v (expected a positive number of seconds)", period)
+	}
+	return nil
+}
+
+// parseBaseTime parses a T0 given as Unix seconds or an RFC 3339 time.
+func parseBaseTime(s string) (int64, error) {
+	t, ok := parseUnixTime(s)
+	if !ok {
+		return 0, fmt.Errorf("invalid base time: %q (expected Unix seconds or e.g. 2024-01-01T00:00:00Z)", s)
+	}
+	return t, nil
+}
+
+// parseUnixTime parses Unix seconds or an RFC 3339 time.
+func parseUnixTime(s string) (int64, bool) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return n, true
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, false
+	}
+	return t.Unix(), true
+}
+
+// paramsSummary describes the parameters codes are generated with, such as
+// "SHA256, 8 digits, 60s period", or returns "" when they are the defaults.
+func (a account) paramsSummary() string {
+	if a.Algorithm == defaultAlgorithm && a.Digits == defaultDigits && a.Period == defaultPeriod && a.T0 == 0 {
+		return ""
+	}
+	summary := fmt.Sprintf("%v, %d digits, %ds period", a.Algorithm, a.Digits, a.Period)
+	if a.T0 != 0 {
+		summary += fmt.Sprintf(", base time %v", time.Unix(a.T0, 0).UTC().Format(time.RFC3339))
+	}
+	return summary
+}
+
+// decodeAccount parses a keyring value. Legacy values are returned as
+// version 0 accounts; use upgradeAccount to bring them up to date.
+func decodeAccount(value string) (account, error) {
+	if !strings.HasPrefix(value, "{") {
+		return account{Secret: value}, nil
+	}
+
+	var a account
+	if err := json.Unmarshal([]byte(value), &a); err != nil {
+		return account{}, fmt.Errorf("invalid keyring entry: %w", err)
+	}
+	if a.Version > accountVersion {
+		return account{}, fmt.Errorf("keyring entry has version %d, but this totp only understands up to %d; please upgrade", a.Version, accountVersion)
+	}
+	return a, nil
+}
+
+// upgradeAccount migrates a to accountVersion, filling in defaults for
+// fields older versions did not have. It reports whether anything changed.
+func upgradeAccount(a *account) bool {
+	switch a.Version {
+	case accountVersion:
+		return false
+	case 0:
+		*a = newAccount(a.Secret)
+	}
+	a.Version = accountVersion
+	return true
+}
+
+func encodeAccount(a account) (string, error) {
+	if a.Protected != nil {
+		a.Secret = ""
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// code returns the TOTP code for t using the account's parameters.
+func (a account) code(t time.Time) (string, error) {
+	if a.Digits <= 0 || a.Period <= 0 {
+		return "", fmt.Errorf("invalid account parameters (digits %d, period %d)", a.Digits, a.Period)
+	}
+	if t.Unix() < a.T0 {
+		return "", fmt.Errorf("time %v is before the account's base time %v", t.Unix(), a.T0)
+	}
+
+	h, err := hashFunc(a.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := decodeSecret(a.Secret)
+	if err != nil {
+		return "", err
+	}
+	defer wipe(key)
+
+	return hotpCode(key, a.step(t), a.Digits, h), nil
+}
+
+// step returns the number of the TOTP time step t falls in, counted from T0.
+// t must not be before T0.
+func (a account) step(t time.Time) uint64 {
+	return uint64(t.Unix()-a.T0) / uint64(a.Period)
+}
+
+// stepStart returns the Unix time the time step containing t began.
+func (a account) stepStart(t time.Time) int64 {
+	return a.T0 + int64(a.step(t))*int64(a.Period)
+}
+
+// expiresIn returns the seconds left in the time step containing t.
+func (a account) expiresIn(t time.Time) int {
+	return int(a.stepStart(t) + int64(a.Period) - t.Unix())
+}
+
+// backupCodes returns the TOTP code of each backup secret for t.
+func (a account) backupCodes(t time.Time) ([]string, error) {
+	codes := make([]string, 0, len(a.Backups))
+	for _, secret := range a.Backups {
+		b := a
+		b.Secret = secret
+		code, err := b.code(t)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// hotpCode returns the HOTP code for the account's current counter. It does
+// not advance the counter; see nextHOTPCode.
+func (a account) hotpCode() (string, error) {
+	if a.Digits <= 0 {
+		return "", fmt.Errorf("invalid account parameters (digits %d)", a.Digits)
+	}
+
+	h, err := hashFunc(a.Algorithm)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := decodeSecret(a.Secret)
+	if err != nil {
+		return "", err
+	}
+	defer wipe(key)
+
+	return hotpCode(key, a.Counter, a.Digits, h), nil
+}
+
+// matchOffset searches up to window time steps either side of now for a step
+// whose code equals code, trying the closest steps first. It returns the
+// matching step offset relative to now.
+func (a account) matchOffset(code string, now time.Time, window int) (int, bool, error) {
+	period := time.Duration(a.Period) * time.Second
+	for d := 0; d <= window; d++ {
+		for _, offset := range []int{-d, d} {
+			got, err := a.code(now.Add(time.Duration(offset) * period))
+			if err != nil {
+				return 0, false, err
+			}
+			if got == code {
+				return offset, true, nil
+			}
+			if d == 0 {
+				break
+			}
+		}
+	}
+	return 0, false, nil
+}
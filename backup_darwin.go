@@ -0,0 +1,102 @@
+//go:build darwin
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const securityTool = "/usr/bin/security"
+
+// nativeExportAvailable reports whether `backup --to-keyring-export` can
+// write a keychain file: only with the macOS keychain backend, and only if
+// the security tool is installed.
+func nativeExportAvailable() bool {
+	if _, ok := store.(systemKeyring); !ok {
+		return false
+	}
+	_, err := os.Stat(securityTool)
+	return err == nil
+}
+
+// nativeExportKind names the file nativeExport writes, for messages.
+const nativeExportKind = "keychain file"
+
+// nativeExport creates a new keychain file at path, locked with passphrase,
+// holding a copy of the keyring item of each name under the current service.
+// Keychain Access can open it and copy the items back into the login
+// keychain. Commands are fed to `security -i` on stdin, as go-keyring does,
+// so no secret shows up in the process list.
+func nativeExport(path string, names []string, passphrase []byte) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%v already exists; choose another path or delete it", path)
+	}
+
+	// create-keychain adds the new keychain to the search list, where it
+	// would shadow the login keychain for totp's own lookups; put the list
+	// back as it was once the items are in.
+	out, err := exec.Command(securityTool, "list-keychains", "-d", "user").Output()
+	if err != nil {
+		return fmt.Errorf("security list-keychains: %w", err)
+	}
+	var searchList []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if kc := strings.Trim(strings.TrimSpace(scanner.Text()), `"`); kc != "" {
+			searchList = append(searchList, kc)
+		}
+	}
+
+	var script strings.Builder
+	fmt.Fprintf(&script, "create-keychain -p %v %v\n", securityQuote(string(passphrase)), securityQuote(path))
+	for _, name := range names {
+		value, err := keyringGet(name)
+		if err != nil {
+			return fmt.Errorf("%v: %w", name, err)
+		}
+		// Stored the way go-keyring stores it, so it reads back unchanged.
+		value = "go-keyring-base64:" + base64.StdEncoding.EncodeToString([]byte(value))
+		fmt.Fprintf(&script, "add-generic-password -s %v -a %v -w %v %v\n",
+			securityQuote(serviceName), securityQuote(name), securityQuote(value), securityQuote(path))
+	}
+	fmt.Fprintf(&script, "lock-keychain %v\n", securityQuote(path))
+
+	cmd := exec.Command(securityTool, "-i")
+	cmd.Stdin = strings.NewReader(script.String())
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+
+	restore := exec.Command(securityTool, append([]string{"list-keychains", "-d", "user", "-s"}, searchList...)...)
+	if err := restore.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not restore the keychain search list: %v\n", err)
+	}
+
+	if runErr != nil || stderr.Len() != 0 {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = runErr.Error()
+		}
+		os.Remove(path)
+		return errors.New("security: " + msg)
+	}
+	return nil
+}
+
+// securityQuote quotes s for the `security -i` command line, which splits
+// words like a shell.
+func securityQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'"'"'`) + "'"
+}
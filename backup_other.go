@@ -0,0 +1,17 @@
+//go:build !darwin
+
+package main
+
+import "errors"
+
+// nativeExportAvailable reports whether `backup --to-keyring-export` can
+// use the platform's own export. Only the macOS keychain has one so far.
+func nativeExportAvailable() bool {
+	return false
+}
+
+const nativeExportKind = ""
+
+func nativeExport(path string, names []string, passphrase []byte) error {
+	return errors.New("native keyring export is not supported on this platform")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+)
+
+// colorMode is the --color preference: colorAuto colors output to terminals
+// unless NO_COLOR is set.
+var colorMode = colorAuto
+
+func checkColorMode(mode string) error {
+	switch mode {
+	case colorAuto, colorAlways, colorNever:
+		return nil
+	default:
+		return fmt.Errorf("unknown color mode %q (expected %v, %v or %v)", mode, colorAuto, colorAlways, colorNever)
+	}
+}
+
+// useColor reports whether output to f should be colored.
+func useColor(f *os.File) bool {
+	switch colorMode {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	default:
+		return os.Getenv("NO_COLOR") == "" && isTerminal(f)
+	}
+}
+
+// red wraps s in the ANSI escape codes for red text when color is on.
+func red(s string, on bool) string {
+	if !on {
+		return s
+	}
+	return "\x1b[31m" + s + "\x1b[0m"
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// config is the optional configuration file: ~/.config/totp/config.json
+// (under $XDG_CONFIG_HOME if set), or the older ~/.totp-config.json. Every
+// field is optional; flags and environment variables take precedence.
+type config struct {
+	Profiles map[string]profile `json:"profiles,omitempty"`
+
+	KeyringBackend    string `json:"keyring_backend,omitempty"`    // as for --keyring-backend
+	KeyringCollection string `json:"keyring_collection,omitempty"` // as for --keyring-collection
+	Service           string `json:"service,omitempty"`            // keyring service of the default profile
+
+	// Defaults for add, as for TOTP_DEFAULT_ALGORITHM, TOTP_DEFAULT_DIGITS
+	// and TOTP_DEFAULT_PERIOD.
+	Algorithm string `json:"algorithm,omitempty"`
+	Digits    int    `json:"digits,omitempty"`
+	Period    int    `json:"period,omitempty"`
+
+	Color       string `json:"color,omitempty"`        // as for --color
+	IndexFormat string `json:"index_format,omitempty"` // as for --index-format
+	IndexOrder  string `json:"index_order,omitempty"`  // as for --index-order
+
+	// Aliases maps a command name of your own to the arguments it stands
+	// for, e.g. "g": "get --copy".
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+// configKeys are the top-level keys config understands; others are warned
+// about, as they are most likely typos.
+var configKeys = []string{"profiles", "keyring_backend", "keyring_collection", "service", "algorithm", "digits", "period", "color", "index_format", "index_order", "aliases"}
+
+// configFilePath returns the configuration file to read: the first of
+// ~/.config/totp/config.json and ~/.totp-config.json that exists, or the
+// former if neither does. With --home, both are looked for under it.
+func configFilePath() (string, error) {
+	home, err := homeDir()
+	if err != nil {
+		return "", err
+	}
+
+	configHome := filepath.Join(home, ".config")
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" && homeOverride == "" {
+		configHome = xdg
+	}
+	paths := []string{filepath.Join(configHome, "totp", "config.json"), filepath.Join(home, ".totp-config.json")}
+	for _, path := range paths {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return paths[0], nil
+}
+
+// configWarned records that unknown configuration keys have been warned
+// about, since the file is read more than once per run.
+var configWarned bool
+
+// readConfig reads the configuration file. A missing file is an empty
+// configuration.
+func readConfig() (config, error) {
+	path, err := configFilePath()
+	if err != nil {
+		return config{}, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return config{}, nil
+		}
+		return config{}, err
+	}
+
+	var c config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return config{}, fmt.Errorf("config %v is invalid: %w", path, err)
+	}
+	if !configWarned {
+		configWarned = true
+		var keys map[string]json.RawMessage
+		_ = json.Unmarshal(data, &keys)
+		var unknown []string
+		for key := range keys {
+			if !slices.Contains(configKeys, key) {
+				unknown = append(unknown, key)
+			}
+		}
+		sort.Strings(unknown)
+		for _, key := range unknown {
+			fmt.Fprintf(os.Stderr, "Warning: unknown key %q in config %v\n", key, path)
+		}
+	}
+	return c, nil
+}
+
+// applyConfigDefaults fills in the add flags named in c for which neither the
+// flag nor its environment variable was given.
+func applyConfigDefaults(c config, cmd *cobra.Command) error {
+	defaults := []struct {
+		flag, env, value string
+	}{
+		{"algorithm", "TOTP_DEFAULT_ALGORITHM", c.Algorithm},
+		{"digits", "TOTP_DEFAULT_DIGITS", strconv.Itoa(c.Digits)},
+		{"period", "TOTP_DEFAULT_PERIOD", strconv.Itoa(c.Period)},
+	}
+	for _, d := range defaults {
+		if d.value == "" || d.value == "0" || os.Getenv(d.env) != "" || cmd.Flags().Changed(d.flag) {
+			continue
+		}
+		if err := cmd.Flags().Lookup(d.flag).Value.Set(d.value); err != nil {
+			return fmt.Errorf("config %v: %w", d.flag, err)
+		}
+	}
+	return nil
+}
+
+// expandAlias replaces a leading alias from the configuration file in args
+// with the arguments it stands for. Built-in commands cannot be shadowed.
+func expandAlias(root *cobra.Command, args []string) []string {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return args
+	}
+	if cmd, _, err := root.Find(args[:1]); err == nil && cmd != root {
+		return args
+	}
+	if args[0] == "help" || strings.HasPrefix(args[0], "__") {
+		return args
+	}
+
+	c, err := readConfig()
+	if err != nil {
+		// Reported again, with context, once the command runs.
+		return args
+	}
+	alias, ok := c.Aliases[args[0]]
+	if !ok {
+		return args
+	}
+	return append(strings.Fields(alias), args[1:]...)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// doctorIssue is a problem found by `totp doctor`, with the repair --fix
+// offers for it.
+type doctorIssue struct {
+	problem string
+	fix     string // imperative, e.g. "Remove them from the index"
+	apply   func() error
+	// blocking issues stop the checks after them until fixed: those read
+	// the index, and reading a corrupt one moves it aside on the spot.
+	blocking bool
+}
+
+// doctorChecks run in order, each after the fixes of the ones before.
+var doctorChecks = []func() (*doctorIssue, error){
+	checkCorruptIndex,
+	checkDanglingNames,
+	checkLegacyEntries,
+}
+
+// checkCorruptIndex reports an index file that cannot be parsed. Unlike
+// readIndex it leaves the file where it is.
+func checkCorruptIndex() (*doctorIssue, error) {
+	path, err := indexFilePath()
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var idx indexFile
+	parseErr := indexCodecFor(path).unmarshal(b, &idx)
+	if parseErr == nil {
+		return nil, nil
+	}
+
+	return &doctorIssue{
+		problem: fmt.Sprintf("The index %v is corrupt (%v).", path, parseErr),
+		fix:     fmt.Sprintf("Move it to %v and rebuild it from the keyring", freeBackupPath(path)),
+		apply: func() error {
+			if _, _, err := setAsideCorruptIndex(path); err != nil {
+				return err
+			}
+			_, err := listItemsFromKeyring()
+			return err
+		},
+		blocking: true,
+	}, nil
+}
+
+// checkDanglingNames reports index names whose keyring entry is gone, as
+// `totp prune` would remove.
+func checkDanglingNames() (*doctorIssue, error) {
+	_, missing, err := splitIndexNames()
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) == 0 {
+		return nil, nil
+	}
+
+	var names []string
+	for name := range missing {
+		names = append(names, name)
+	}
+	sortIndexNames(names)
+	return &doctorIssue{
+		problem: fmt.Sprintf("%d index names have no keyring entry: %v.", len(names), strings.Join(names, ", ")),
+		fix:     "Remove them from the index",
+		apply: func() error {
+			return removeNamesFromIndex(missing)
+		},
+	}, nil
+}
+
+// checkLegacyEntries reports entries still in the legacy format, as `totp
+// migrate` would upgrade. Entries that cannot be read are left to get and
+// stats to report.
+func checkLegacyEntries() (*doctorIssue, error) {
+	kept, _, err := splitIndexNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range kept {
+		value, err := keyringGet(name)
+		if err != nil {
+			continue
+		}
+		a, err := decodeAccount(value)
+		if err != nil {
+			continue
+		}
+		if upgradeAccount(&a) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	return &doctorIssue{
+		problem: fmt.Sprintf("%d entries are in the legacy format: %v.", len(names), strings.Join(names, ", ")),
+		fix:     "Upgrade them to the current format",
+		apply: func() error {
+			var errs []error
+			for _, name := range names {
+				if _, err := migrateItem(name); err != nil {
+					errs = append(errs, fmt.Errorf("%v: %w", name, err))
+				}
+			}
+			return errors.Join(errs...)
+		},
+	}, nil
+}
+
+// runDoctor prints every issue found. With fix it applies the repair of
+// each, asking first unless yes, and prints a summary.
+func runDoctor(fix, yes bool) error {
+	found, fixed, failed := 0, 0, 0
+	for _, check := range doctorChecks {
+		issue, err := check()
+		if err != nil {
+			return err
+		}
+		if issue == nil {
+			continue
+		}
+		found++
+		fmt.Println(issue.problem)
+
+		if !fix {
+			fmt.Printf("  Fix: %v.\n", issue.fix)
+			if issue.blocking {
+				fmt.Fprintln(os.Stderr, "Note: the remaining checks are skipped until this is fixed.")
+				break
+			}
+			continue
+		}
+		if !yes {
+			ok, err := confirm(issue.fix + "?")
+			if err != nil {
+				return err
+			}
+			if !ok {
+				if issue.blocking {
+					fmt.Fprintln(os.Stderr, "Note: the remaining checks are skipped until this is fixed.")
+					break
+				}
+				continue
+			}
+		}
+		if err := issue.apply(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to fix: %v\n", err)
+			failed++
+			if issue.blocking {
+				break
+			}
+			continue
+		}
+		fixed++
+	}
+
+	switch {
+	case found == 0:
+		infof("No issues found.\n")
+	case !fix:
+		return fmt.Errorf("Found %d issues; run \"totp doctor --fix\" to repair them", found)
+	default:
+		infof("Fixed %d of %d issues.\n", fixed, found)
+		if failed > 0 {
+			return fmt.Errorf("Failed to fix %d of %d issues", failed, found)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "errors"
+
+// Error kinds that callers can tell apart with errors.Is. The CLI maps them
+// to distinct exit statuses; see exitCode.
+var (
+	ErrNameNotFound       = errors.New("Given name is not found")
+	ErrNameExists         = errors.New("Name already exists")
+	ErrInvalidSecret      = errors.New("Invalid secret")
+	ErrKeyringUnavailable = errors.New("keyring unavailable")
+)
+
+// exitCode returns the process exit status for an error returned by a
+// command: 2 to 5 for the error kinds above, and 1 for anything else.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, ErrNameNotFound):
+		return 2
+	case errors.Is(err, ErrNameExists):
+		return 3
+	case errors.Is(err, ErrInvalidSecret):
+		return 4
+	case errors.Is(err, ErrKeyringUnavailable):
+		return 5
+	default:
+		return 1
+	}
+}
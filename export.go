@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	exportFormatURI  = "uri"
+	exportFormatJSON = "json"
+)
+
+// exportVersion is the current format of JSON and encrypted exports.
+const exportVersion = 1
+
+// exportFile is the document written by `export --format json`.
+type exportFile struct {
+	Version int           `json:"version"`
+	Entries []exportEntry `json:"entries"`
+}
+
+// exportEntry is one exported entry: its name and unlocked account.
+type exportEntry struct {
+	Name string `json:"name"`
+	account
+}
+
+// encryptedExport wraps an export of either format, sealed under a
+// passphrase with the same scheme as protected entries.
+type encryptedExport struct {
+	Version   int           `json:"version"`
+	Format    string        `json:"format"`
+	Encrypted *sealedSecret `json:"encrypted"`
+}
+
+// selectExportNames returns the names to export: those matching args (names
+// or glob patterns), or every registered name when there are none, keeping
+// only entries that carry at least one of tags when any are given.
+func selectExportNames(args, tags []string) ([]string, error) {
+	var names []string
+	if len(args) == 0 {
+		var err error
+		if names, err = listItems(); err != nil {
+			return nil, err
+		}
+	} else {
+		var unmatched []string
+		var err error
+		if names, unmatched, err = expandNames(args); err != nil {
+			return nil, err
+		}
+		if len(unmatched) != 0 {
+			return nil, fmt.Errorf("No names match \"%v\"", strings.Join(unmatched, "\", \""))
+		}
+	}
+
+	if len(tags) != 0 {
+		idx, err := readIndex()
+		if err != nil {
+			return nil, err
+		}
+		names = slices.DeleteFunc(names, func(name string) bool {
+			return !slices.ContainsFunc(idx.Entries[name].Tags, func(tag string) bool {
+				return slices.Contains(tags, tag)
+			})
+		})
+	}
+
+	if len(names) == 0 {
+		return nil, errors.New("No entries matched; nothing to export")
+	}
+	return names, nil
+}
+
+// exportItems renders the entries stored under names in format, unlocking
+// protected ones as needed. URIs only carry the primary secret; use the JSON
+// format to keep backup secrets and tags.
+func exportItems(names []string, format, labelFormat string) (string, error) {
+	var sb strings.Builder
+	doc := exportFile{Version: exportVersion, Entries: []exportEntry{}}
+	for _, name := range names {
+		a, err := getUnlockedItem(name)
+		if err != nil {
+			return "", fmt.Errorf("%v: %w", name, err)
+		}
+		a.Protected = nil
+
+		switch format {
+		case exportFormatURI:
+			uri, err := buildOTPAuthURL(name, a, labelFormat)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(uri + "\n")
+		case exportFormatJSON:
+			doc.Entries = append(doc.Entries, exportEntry{Name: name, account: a})
+		default:
+			return "", fmt.Errorf("unknown export format %q (expected %v or %v)", format, exportFormatURI, exportFormatJSON)
+		}
+	}
+
+	if format == exportFormatJSON {
+		b, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return "", err
+		}
+		sb.Write(b)
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// encryptExport seals an export under passphrase.
+func encryptExport(data, format string, passphrase []byte) (string, error) {
+	sealed, err := sealSecret(data, passphrase)
+	if err != nil {
+		return "", err
+	}
+	b, err := json.MarshalIndent(encryptedExport{Version: exportVersion, Format: format, Encrypted: sealed}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(b) + "\n", nil
+}
@@ -3,18 +3,24 @@ module github.com/munim/totp-cli
 go 1.22.2
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/atotto/clipboard v0.1.4
+	github.com/danieljoos/wincred v1.2.2
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/makiuchi-d/gozxing v0.1.1
+	github.com/mattn/go-runewidth v0.0.16
 	github.com/spf13/cobra v1.8.1
-	github.com/xlzd/gotp v0.1.0
 	github.com/zalando/go-keyring v0.2.6
+	golang.org/x/crypto v0.28.0
+	golang.org/x/image v0.18.0
+	golang.org/x/term v0.25.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	al.essio.dev/pkg/shellescape v1.5.1 // indirect
-	github.com/danieljoos/wincred v1.2.2 // indirect
-	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
+	github.com/rivo/uniseg v0.2.0 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/stretchr/testify v1.10.0 // indirect
 	golang.org/x/sys v0.26.0 // indirect
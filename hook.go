@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultExecTimeout is how long a `get --exec` command may run before it is
+// killed.
+const defaultExecTimeout = 10 * time.Second
+
+// runExecHook runs command through the shell once a code has been generated,
+// e.g. to type it with ydotool. The code is passed in TOTP_CODE and on
+// standard input, never as an argument, where other users could see it in
+// the process list. The command's output goes to stderr so that stdout still
+// holds only what get printed.
+func runExecHook(command string, info codeInfo, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(),
+		"TOTP_CODE="+info.Code,
+		"TOTP_NAME="+info.Name,
+		"TOTP_EXPIRES_IN="+strconv.Itoa(info.ExpiresIn),
+	)
+	cmd.Stdin = strings.NewReader(info.Code + "\n")
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	// Do not wait forever on a background child holding the output open.
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("--exec command timed out after %v", timeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return fmt.Errorf("--exec command exited with status %d", exitErr.ExitCode())
+	}
+	if err != nil {
+		return fmt.Errorf("--exec command: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	importFormat1Password = "1password"
+	importFormatBitwarden = "bitwarden"
+)
+
+// Column headers, lowercased, that password manager CSV exports use for the
+// entry title and its one-time password. 1Password has used several over the
+// years; Bitwarden's CSV export uses name and login_totp.
+var (
+	importNameColumns = map[string][]string{
+		importFormat1Password: {"title", "name"},
+		importFormatBitwarden: {"name"},
+	}
+	importTOTPColumns = map[string][]string{
+		importFormat1Password: {"otpauth", "one-time password", "one-time password 1", "totp", "otp"},
+		importFormatBitwarden: {"login_totp"},
+	}
+)
+
+// importRecord is one entry with a one-time password found in an export.
+type importRecord struct {
+	where string // position in the export, e.g. "row 3", for messages
+	name  string
+	a     account
+	err   error
+}
+
+// bitwardenExport is the part of an unencrypted Bitwarden JSON export that
+// import reads.
+type bitwardenExport struct {
+	Encrypted bool `json:"encrypted"`
+	Items     []struct {
+		Name  string `json:"name"`
+		Login *struct {
+			Username string `json:"username"`
+			TOTP     string `json:"totp"`
+		} `json:"login"`
+	} `json:"items"`
+}
+
+// parseImport extracts the entries that carry a one-time password from a
+// password manager export in format. Bitwarden exports may be JSON or CSV;
+// 1Password ones are CSV. Entries without a one-time password are left out.
+func parseImport(data []byte, format string) ([]importRecord, error) {
+	switch format {
+	case importFormat1Password:
+		return parseImportCSV(data, format)
+	case importFormatBitwarden:
+		if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+			return parseBitwardenJSON(data)
+		}
+		return parseImportCSV(data, format)
+	default:
+		return nil, fmt.Errorf("unknown import format %q (expected %v or %v)", format, importFormat1Password, importFormatBitwarden)
+	}
+}
+
+func parseBitwardenJSON(data []byte) ([]importRecord, error) {
+	var export bitwardenExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("invalid Bitwarden export: %w", err)
+	}
+	if export.Encrypted {
+		return nil, errors.New("Encrypted Bitwarden exports are not supported; export as unencrypted JSON or CSV")
+	}
+
+	var records []importRecord
+	for i, item := range export.Items {
+		if item.Login == nil || strings.TrimSpace(item.Login.TOTP) == "" {
+			continue
+		}
+		r := importRecord{where: fmt.Sprintf("item %d", i+1), name: strings.TrimSpace(item.Name)}
+		r.a, r.err = parseImportedTOTP(item.Login.TOTP)
+		if r.err == nil && r.a.Account == "" {
+			r.a.Account = item.Login.Username
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func parseImportCSV(data []byte, format string) ([]importRecord, error) {
+	cr := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	cr.FieldsPerRecord = -1
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid CSV export: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	column := func(candidates []string) int {
+		for i, h := range rows[0] {
+			for _, c := range candidates {
+				if strings.EqualFold(strings.TrimSpace(h), c) {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+	nameCol, totpCol := column(importNameColumns[format]), column(importTOTPColumns[format])
+	if totpCol < 0 {
+		return nil, fmt.Errorf("No one-time password column in the CSV header (expected one of %v)", strings.Join(importTOTPColumns[format], ", "))
+	}
+
+	var records []importRecord
+	for i, row := range rows[1:] {
+		if totpCol >= len(row) || strings.TrimSpace(row[totpCol]) == "" {
+			continue
+		}
+		r := importRecord{where: fmt.Sprintf("row %d", i+2)}
+		if nameCol >= 0 && nameCol < len(row) {
+			r.name = strings.TrimSpace(row[nameCol])
+		}
+		r.a, r.err = parseImportedTOTP(row[totpCol])
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// parseImportedTOTP parses a password manager's one-time password field: an
+// otpauth URI or, as Bitwarden also allows, a bare Base32 secret.
+func parseImportedTOTP(value string) (account, error) {
+	value = strings.TrimSpace(value)
+	lower := strings.ToLower(value)
+	switch {
+	case strings.HasPrefix(lower, "otpauth://"):
+		return parseOTPAuthURL(value)
+	case strings.HasPrefix(lower, "steam://"):
+		return account{}, errors.New("Steam Guard codes are not supported")
+	default:
+		secret, err := normalizeAndValidateSecret(value)
+		if err != nil {
+			return account{}, err
+		}
+		return newAccount(secret), nil
+	}
+}
+
+// importName returns the name to store r under: its title in the export or,
+// failing that, the issuer or account from its otpauth URI.
+func (r importRecord) importName() string {
+	for _, name := range []string{r.name, r.a.Issuer, r.a.Account} {
+		if name = strings.TrimSpace(name); name != "" {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/makiuchi-d/gozxing"
+)
+
+// importImageExts are the file extensions import-dir considers images.
+var importImageExts = map[string]bool{
+	".png": true, ".jpg": true, ".jpeg": true, ".gif": true, ".bmp": true,
+}
+
+// dirScan is the outcome of decoding one image for import-dir.
+type dirScan struct {
+	file string // base name of the image
+	name string // entry name: the file name without its extension
+	a    account
+	err  error
+}
+
+// scanImageFile decodes the QR code in the image at path, trying every frame
+// of animated GIFs, and parses its otpauth URI.
+func scanImageFile(path string, hints map[gozxing.DecodeHintType]interface{}) (account, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return account{}, err
+	}
+	defer f.Close()
+
+	text, err := decodeQRFrames(f, hints)
+	if err != nil {
+		return account{}, err
+	}
+	return parseScannedText(text)
+}
+
+// scanDir decodes every image directly inside dir with up to jobs images in
+// flight at once. Results are sorted by file name whatever order the workers
+// finish in.
+func scanDir(dir string, jobs int, hints map[gozxing.DecodeHintType]interface{}) ([]dirScan, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []dirScan
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.Type().IsRegular() && importImageExts[ext] {
+			results = append(results, dirScan{file: e.Name(), name: strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].file < results[j].file })
+
+	if jobs < 1 {
+		jobs = 1
+	}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for range min(jobs, len(results)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each worker writes only the results it was handed.
+			for i := range work {
+				r := &results[i]
+				r.a, r.err = scanImageFile(filepath.Join(dir, r.file), hints)
+			}
+		}()
+	}
+	for i := range results {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+	return results, nil
+}
+
+// promptImportName asks for another name for an import whose name is taken,
+// until a free one is given. An empty answer (or end of input) skips the
+// import and returns "".
+func promptImportName(label, name string) (string, error) {
+	for {
+		exists, err := nameExists(name)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return name, nil
+		}
+
+		line, err := readLine(fmt.Sprintf("%v: name \"%v\" already exists. Type new name (empty to skip): ", label, name))
+		if err != nil {
+			return "", err
+		}
+		if name = strings.TrimSpace(line); name == "" {
+			return "", nil
+		}
+	}
+}
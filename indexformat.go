@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	indexFormatJSON = "json"
+	indexFormatYAML = "yaml"
+	indexFormatTOML = "toml"
+)
+
+// indexFormat is the --index-format preference. Empty picks the format from
+// the index file's extension, JSON unless it is .yaml, .yml or .toml.
+var indexFormat string
+
+func checkIndexFormat(format string) error {
+	switch format {
+	case "", indexFormatJSON, indexFormatYAML, indexFormatTOML:
+		return nil
+	default:
+		return fmt.Errorf("unknown index format %q (expected %v, %v or %v)", format, indexFormatJSON, indexFormatYAML, indexFormatTOML)
+	}
+}
+
+const (
+	indexOrderName = "name"
+	indexOrderNone = "none"
+)
+
+// indexOrder is the --index-order preference: the index keeps its names
+// sorted (indexOrderName, the default) or in the order they were added, new
+// names last (indexOrderNone).
+var indexOrder string
+
+func checkIndexOrder(order string) error {
+	switch order {
+	case "", indexOrderName, indexOrderNone:
+		return nil
+	default:
+		return fmt.Errorf("unknown index order %q (expected %v or %v)", order, indexOrderName, indexOrderNone)
+	}
+}
+
+// sortIndexNames sorts names unless the index keeps insertion order.
+func sortIndexNames(names []string) {
+	if indexOrder != indexOrderNone {
+		sort.Strings(names)
+	}
+}
+
+// indexExt returns the extension of index files in the --index-format
+// format, for the default index locations.
+func indexExt() string {
+	if indexFormat == "" {
+		return "." + indexFormatJSON
+	}
+	return "." + indexFormat
+}
+
+// indexCodec reads and writes the index in one file format. The index only
+// holds names and metadata; secrets never leave the keyring.
+type indexCodec interface {
+	marshal(idx indexFile) ([]byte, error)
+	unmarshal(data []byte, idx *indexFile) error
+}
+
+// indexExtFormat returns the format the extension of path calls for, or ""
+// if it names none.
+func indexExtFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return indexFormatJSON
+	case ".yaml", ".yml":
+		return indexFormatYAML
+	case ".toml":
+		return indexFormatTOML
+	default:
+		return ""
+	}
+}
+
+// checkIndexPathFormat refuses an index path whose extension names another
+// format than --index-format, such as a profile's work.json with
+// --index-format toml: parsing it as the wrong format would find it corrupt.
+func checkIndexPathFormat(path string) error {
+	if format := indexExtFormat(path); format != "" && indexFormat != "" && format != indexFormat {
+		return fmt.Errorf("Index %v is %v by its extension, but the index format is set to %v; rename the file or change the setting", path, strings.ToUpper(format), strings.ToUpper(indexFormat))
+	}
+	return nil
+}
+
+// indexCodecFor returns the codec for the index at path: the one its
+// extension calls for, and otherwise the --index-format one, JSON by
+// default.
+func indexCodecFor(path string) indexCodec {
+	format := indexExtFormat(path)
+	if format == "" {
+		format = indexFormat
+	}
+	switch format {
+	case indexFormatYAML:
+		return yamlIndexCodec{}
+	case indexFormatTOML:
+		return tomlIndexCodec{}
+	default:
+		return jsonIndexCodec{}
+	}
+}
+
+type jsonIndexCodec struct{}
+
+func (jsonIndexCodec) marshal(idx indexFile) ([]byte, error) {
+	b, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+func (jsonIndexCodec) unmarshal(data []byte, idx *indexFile) error {
+	return json.Unmarshal(data, idx)
+}
+
+type yamlIndexCodec struct{}
+
+func (yamlIndexCodec) marshal(idx indexFile) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(idx); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (yamlIndexCodec) unmarshal(data []byte, idx *indexFile) error {
+	return yaml.Unmarshal(data, idx)
+}
+
+type tomlIndexCodec struct{}
+
+func (tomlIndexCodec) marshal(idx indexFile) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.Indent = ""
+	if err := enc.Encode(idx); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (tomlIndexCodec) unmarshal(data []byte, idx *indexFile) error {
+	_, err := toml.Decode(string(data), idx)
+	return err
+}
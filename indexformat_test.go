@@ -0,0 +1,203 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIndexCodecRoundTrip(t *testing.T) {
+	idx := indexFile{
+		Names: []string{"github", "work.vpn", `say "hi"`, "ünïcode"},
+		Entries: map[string]indexEntry{
+			"github": {
+				Issuer:      "GitHub",
+				Tags:        []string{"dev", "2fa"},
+				Created:     1700000000,
+				Modified:    1700000100,
+				LastUsed:    1700000200,
+				RotateAfter: 90 * 24 * 60 * 60,
+				Rotated:     1700000300,
+			},
+			"work.vpn":  {Issuer: "Corp: VPN"},
+			`say "hi"`:  {Tags: []string{`back\slash`}},
+			"ünïcode":   {Issuer: "Ĳssuer\twith tab"},
+			"untouched": {},
+		},
+	}
+
+	for _, format := range []string{indexFormatJSON, indexFormatYAML, indexFormatTOML} {
+		t.Run(format, func(t *testing.T) {
+			codec := indexCodecFor("index." + format)
+			b, err := codec.marshal(idx)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var got indexFile
+			if err := codec.unmarshal(b, &got); err != nil {
+				t.Fatalf("unmarshal: %v\n%s", err, b)
+			}
+			if !reflect.DeepEqual(got, idx) {
+				t.Errorf("round trip changed the index:\ngot  %#v\nwant %#v\n%s", got, idx, b)
+			}
+		})
+	}
+}
+
+func TestIndexCodecEmpty(t *testing.T) {
+	for _, format := range []string{indexFormatJSON, indexFormatYAML, indexFormatTOML} {
+		t.Run(format, func(t *testing.T) {
+			codec := indexCodecFor("index." + format)
+			b, err := codec.marshal(indexFile{})
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var got indexFile
+			if err := codec.unmarshal(b, &got); err != nil {
+				t.Fatalf("unmarshal: %v\n%s", err, b)
+			}
+			if len(got.Names) != 0 || len(got.Entries) != 0 {
+				t.Errorf("got %#v, want an empty index", got)
+			}
+		})
+	}
+}
+
+// TestTOMLIndexHandEdited reads TOML written by hand rather than by the
+// codec, using syntax the codec never produces.
+func TestTOMLIndexHandEdited(t *testing.T) {
+	tests := []struct {
+		name string
+		toml string
+		want indexFile
+	}{
+		{
+			name: "comments and unknown keys",
+			toml: `# my accounts
+names = ["github"] # trailing comment
+editor = "vim"
+
+[entries.github]
+issuer = "GitHub"
+color = "blue"
+`,
+			want: indexFile{
+				Names:   []string{"github"},
+				Entries: map[string]indexEntry{"github": {Issuer: "GitHub"}},
+			},
+		},
+		{
+			name: "inline tables",
+			toml: `names = ["github", "gitlab"]
+entries = { github = { issuer = "GitHub", tags = ["dev"] }, gitlab = { created = 1700000000 } }
+`,
+			want: indexFile{
+				Names: []string{"github", "gitlab"},
+				Entries: map[string]indexEntry{
+					"github": {Issuer: "GitHub", Tags: []string{"dev"}},
+					"gitlab": {Created: 1700000000},
+				},
+			},
+		},
+		{
+			name: "dotted keys and literal and multiline strings",
+			toml: `names = [
+  'C:\work',
+  """multi""",
+]
+entries.'C:\work'.issuer = '''Corp'''
+`,
+			want: indexFile{
+				Names:   []string{`C:\work`, "multi"},
+				Entries: map[string]indexEntry{`C:\work`: {Issuer: "Corp"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got indexFile
+			if err := (tomlIndexCodec{}).unmarshal([]byte(tt.toml), &got); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTOMLIndexInvalid(t *testing.T) {
+	for _, text := range []string{
+		`names = ["unterminated"`,
+		`names = "not an array"`,
+		"[entries.github]\ncreated = \"yesterday\"\n",
+	} {
+		var got indexFile
+		if err := (tomlIndexCodec{}).unmarshal([]byte(text), &got); err == nil {
+			t.Errorf("unmarshal(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestIndexCodecFor(t *testing.T) {
+	tests := []struct {
+		path, format string
+		want         indexCodec
+	}{
+		{"/home/u/.totp.json", "", jsonIndexCodec{}},
+		{"/home/u/.totp.yaml", "", yamlIndexCodec{}},
+		{"/home/u/.totp.YML", "", yamlIndexCodec{}},
+		{"/home/u/.totp.toml", "", tomlIndexCodec{}},
+		{"/home/u/index", "", jsonIndexCodec{}},
+		{"/home/u/index", indexFormatTOML, tomlIndexCodec{}},
+		{"/home/u/index.conf", indexFormatYAML, yamlIndexCodec{}},
+		{"/home/u/work.json", indexFormatTOML, jsonIndexCodec{}},
+	}
+	defer func(saved string) { indexFormat = saved }(indexFormat)
+	for _, tt := range tests {
+		indexFormat = tt.format
+		if got := indexCodecFor(tt.path); got != tt.want {
+			t.Errorf("indexCodecFor(%q) with --index-format %q = %T, want %T", tt.path, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestTOMLIndexOutput(t *testing.T) {
+	b, err := (tomlIndexCodec{}).marshal(indexFile{
+		Names:   []string{"github"},
+		Entries: map[string]indexEntry{"github": {Issuer: "GitHub", Created: 1700000000}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`names = ["github"]`, "[entries.github]", `issuer = "GitHub"`, "created = 1700000000"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("output lacks %q:\n%s", want, b)
+		}
+	}
+	if strings.Contains(string(b), "modified") {
+		t.Errorf("output has an empty field:\n%s", b)
+	}
+}
+
+func TestCheckIndexPathFormat(t *testing.T) {
+	tests := []struct {
+		path, format string
+		ok           bool
+	}{
+		{"/home/u/work.json", "", true},
+		{"/home/u/work.json", indexFormatJSON, true},
+		{"/home/u/work.yml", indexFormatYAML, true},
+		{"/home/u/work", indexFormatTOML, true},
+		{"/home/u/work.json", indexFormatTOML, false},
+		{"/home/u/work.toml", indexFormatYAML, false},
+	}
+	defer func(saved string) { indexFormat = saved }(indexFormat)
+	for _, tt := range tests {
+		indexFormat = tt.format
+		if err := checkIndexPathFormat(tt.path); (err == nil) != tt.ok {
+			t.Errorf("checkIndexPathFormat(%q) with --index-format %q = %v, want ok %v", tt.path, tt.format, err, tt.ok)
+		}
+	}
+}
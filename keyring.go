@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/zalando/go-keyring"
+)
+
+// store is the keyring backend secrets are kept in. It is the system keyring
+// unless another backend is selected with --keyring-backend.
+var store keyring.Keyring = systemKeyring{}
+
+// systemKeyring is the platform keyring as auto-selected by go-keyring.
+type systemKeyring struct{}
+
+func (systemKeyring) Set(service, user, password string) error {
+	return keyring.Set(service, user, password)
+}
+
+func (systemKeyring) Get(service, user string) (string, error) {
+	return keyring.Get(service, user)
+}
+
+func (systemKeyring) Delete(service, user string) error {
+	return keyring.Delete(service, user)
+}
+
+func (systemKeyring) DeleteAll(service string) error {
+	return keyring.DeleteAll(service)
+}
+
+func (systemKeyring) List(service string) ([]string, error) {
+	return listSystemKeyring(service)
+}
+
+// keyringLister is implemented by backends that can enumerate the users
+// stored under a service.
+type keyringLister interface {
+	List(service string) ([]string, error)
+}
+
+var errListUnsupported = errors.New("keyring backend cannot enumerate entries")
+
+// nativeKeyringBackend is the name of the backend go-keyring uses on this
+// platform.
+func nativeKeyringBackend() string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "keychain"
+	case "windows":
+		return "wincred"
+	default:
+		return "secret-service"
+	}
+}
+
+// selectKeyringBackend points store at the named backend, failing if it is
+// not available on this platform. With Secret Service, the collection is
+// then chosen as keyringCollection says.
+func selectKeyringBackend(name string) error {
+	switch name {
+	case "", "auto":
+		store = systemKeyring{}
+	case "file":
+		store = fileKeyring{}
+	case "keychain", "secret-service", "wincred":
+		if name != nativeKeyringBackend() {
+			return fmt.Errorf("keyring backend %q is not supported on %v (available: auto, %v, file)", name, runtime.GOOS, nativeKeyringBackend())
+		}
+		store = systemKeyring{}
+	default:
+		return fmt.Errorf("unknown keyring backend %q (available: auto, %v, file)", name, nativeKeyringBackend())
+	}
+
+	if _, ok := store.(systemKeyring); !ok || nativeKeyringBackend() != "secret-service" {
+		if keyringCollection != "" {
+			return errors.New("--keyring-collection requires the secret-service keyring backend")
+		}
+		return nil
+	}
+
+	idx, err := readIndex()
+	if err != nil {
+		return err
+	}
+	if keyringCollection == "" && idx.Collection == "" {
+		return nil
+	}
+	s, label, err := selectCollection(keyringCollection, idx.Collection)
+	if err != nil {
+		return err
+	}
+	if label != idx.Collection {
+		if err := recordCollection(label); err != nil {
+			return err
+		}
+	}
+	store = s
+	return nil
+}
+
+// recordCollection remembers label as the collection of the current index,
+// so that later runs use it without --keyring-collection. It refuses while
+// the index lists entries: those are kept in the old collection, and reading
+// them from the new one would find them gone and prune them.
+func recordCollection(label string) error {
+	return updateIndex(func(idx *indexFile) error {
+		if len(idx.Names) > 0 {
+			return fmt.Errorf("Cannot switch to the %v while the index lists %d entries kept in the %v; export and delete them, then import them again after switching",
+				collectionName(label), len(idx.Names), collectionName(idx.Collection))
+		}
+		idx.Collection = label
+		return nil
+	})
+}
+
+// collectionName describes the collection labelled label in messages.
+func collectionName(label string) string {
+	if label == "" {
+		return "default keyring collection"
+	}
+	return fmt.Sprintf("keyring collection %q", label)
+}
+
+const (
+	collectionDefault  = "default"
+	collectionHardware = "hardware"
+)
+
+// keyringCollection is the --keyring-collection preference: the label of the
+// Secret Service collection to keep entries in, collectionDefault for the
+// default collection, or collectionHardware for the one detected as
+// hardware-backed. The choice is recorded in the index (see
+// recordCollection); empty uses the recorded collection, and without one the
+// default collection, with no D-Bus calls at all.
+var keyringCollection string
+
+// hardwareCollectionMarkers are the words that mark a Secret Service
+// collection as hardware-backed when they appear in its label. Secret
+// Service itself does not say how a collection is protected, so this relies
+// on naming: a KeePassXC database unlocked with a YubiKey, say, exported as
+// a collection labelled "YubiKey".
+var hardwareCollectionMarkers = []string{"yubikey", "nitrokey", "piv", "smartcard", "smart card", "tpm", "hardware"}
+
+// isHardwareCollectionLabel reports whether a collection label carries one
+// of hardwareCollectionMarkers as a whole word.
+func isHardwareCollectionLabel(label string) bool {
+	words := " " + strings.Join(strings.FieldsFunc(strings.ToLower(label), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ") + " "
+	for _, marker := range hardwareCollectionMarkers {
+		if strings.Contains(words, " "+marker+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// keyringRetries is how many times a failed keyring call is retried before
+// giving up. Only transient errors are retried.
+var keyringRetries = defaultKeyringRetries()
+
+const keyringRetryDelay = 100 * time.Millisecond
+
+func defaultKeyringRetries() int {
+	if v, err := strconv.Atoi(os.Getenv("TOTP_KEYRING_RETRIES")); err == nil && v >= 0 {
+		return v
+	}
+	return 2
+}
+
+// isFatalKeyringError reports whether err is a definite answer from the
+// keyring that retrying cannot change.
+func isFatalKeyringError(err error) bool {
+	return errors.Is(err, keyring.ErrNotFound) || errors.Is(err, keyring.ErrSetDataTooBig)
+}
+
+// lockRetries is how many times a keyring call that failed because the
+// keyring is locked is retried, each time after asking the user to unlock it
+// and press Enter. Zero fails straight away.
+var lockRetries int
+
+// isLockedKeyringError reports whether err means the keyring is locked: the
+// macOS keychain refusing to prompt (security exits with 36,
+// errSecInteractionNotAllowed) or a Secret Service collection that stayed
+// locked, e.g. because the unlock dialog was dismissed.
+func isLockedKeyringError(err error) bool {
+	var exitErr *exec.ExitError
+	if runtime.GOOS == "darwin" && errors.As(err, &exitErr) && exitErr.ExitCode() == 36 {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "failed to unlock correct collection") || strings.Contains(msg, "org.freedesktop.Secret.Error.IsLocked")
+}
+
+// withRetry runs fn, retrying transient failures with exponential backoff.
+// Failures that persist are wrapped with ErrKeyringUnavailable.
+func withRetry(fn func() error) error {
+	delay := keyringRetryDelay
+	err := fn()
+	for i := 0; i < keyringRetries && err != nil && !isFatalKeyringError(err); i++ {
+		time.Sleep(delay)
+		delay *= 2
+		err = fn()
+	}
+	for i := 0; i < lockRetries && err != nil && isLockedKeyringError(err); i++ {
+		fmt.Fprint(os.Stderr, "Keyring locked; press Enter to retry after unlocking it: ")
+		if _, rerr := stdin.ReadString('\n'); rerr != nil {
+			fmt.Fprintln(os.Stderr)
+			break
+		}
+		err = fn()
+	}
+	if err != nil && !isFatalKeyringError(err) {
+		return fmt.Errorf("%w: %w", ErrKeyringUnavailable, err)
+	}
+	return err
+}
+
+// keyringNames enumerates the names stored in the keyring, bypassing the index.
+func keyringNames() ([]string, error) {
+	lister, ok := store.(keyringLister)
+	if !ok {
+		return nil, errListUnsupported
+	}
+
+	var names []string
+	err := withRetry(func() error {
+		var err error
+		names, err = lister.List(serviceName)
+		return err
+	})
+	return names, err
+}
+
+func keyringGet(name string) (string, error) {
+	var secret string
+	err := withRetry(func() error {
+		var err error
+		secret, err = store.Get(serviceName, name)
+		return err
+	})
+	return secret, err
+}
+
+func keyringSet(name, secret string) error {
+	return withRetry(func() error {
+		return store.Set(serviceName, name, secret)
+	})
+}
+
+func keyringDelete(name string) error {
+	return withRetry(func() error {
+		return store.Delete(serviceName, name)
+	})
+}
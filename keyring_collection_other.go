@@ -0,0 +1,11 @@
+//go:build !((dragonfly && cgo) || (freebsd && cgo) || linux || netbsd || openbsd)
+
+package main
+
+import "github.com/zalando/go-keyring"
+
+// selectCollection is only reached with Secret Service, which this platform
+// does not use.
+func selectCollection(pref, recorded string) (keyring.Keyring, string, error) {
+	return systemKeyring{}, "", nil
+}
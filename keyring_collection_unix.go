@@ -0,0 +1,221 @@
+//go:build (dragonfly && cgo) || (freebsd && cgo) || linux || netbsd || openbsd
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	dbus "github.com/godbus/dbus/v5"
+	"github.com/zalando/go-keyring"
+	ss "github.com/zalando/go-keyring/secret_service"
+)
+
+// secretCollection is a Secret Service collection and its label.
+type secretCollection struct {
+	path  dbus.ObjectPath
+	label string
+}
+
+func listCollections(svc *ss.SecretService) ([]secretCollection, error) {
+	prop, err := svc.Object("org.freedesktop.secrets", "/org/freedesktop/secrets").GetProperty("org.freedesktop.Secret.Service.Collections")
+	if err != nil {
+		return nil, err
+	}
+	paths, _ := prop.Value().([]dbus.ObjectPath)
+
+	var out []secretCollection
+	for _, path := range paths {
+		label, err := svc.Object("org.freedesktop.secrets", path).GetProperty("org.freedesktop.Secret.Collection.Label")
+		if err != nil {
+			return nil, err
+		}
+		s, _ := label.Value().(string)
+		out = append(out, secretCollection{path: path, label: s})
+	}
+	return out, nil
+}
+
+// selectCollection returns the backend for the collection pref names (see
+// keyringCollection), given the label recorded in the index, and the label
+// to record. A collection that is named or recorded but missing is an error,
+// never a reason to fall back to another collection.
+func selectCollection(pref, recorded string) (keyring.Keyring, string, error) {
+	if pref == collectionDefault {
+		return systemKeyring{}, "", nil
+	}
+	svc, err := ss.NewSecretService()
+	if err != nil {
+		return nil, "", err
+	}
+	collections, err := listCollections(svc)
+	if err != nil {
+		return nil, "", err
+	}
+
+	label := pref
+	switch {
+	case pref == "":
+		label = recorded
+	case pref == collectionHardware && isHardwareCollectionLabel(recorded):
+		// Detected before: keep it rather than guess again.
+		label = recorded
+	case pref == collectionHardware:
+		var hardware []secretCollection
+		for _, c := range collections {
+			if isHardwareCollectionLabel(c.label) {
+				hardware = append(hardware, c)
+			}
+		}
+		switch len(hardware) {
+		case 0:
+			return nil, "", fmt.Errorf("No hardware-backed keyring collection found (looked for %v in collection labels)", strings.Join(hardwareCollectionMarkers, ", "))
+		case 1:
+			return collectionKeyring{path: hardware[0].path}, hardware[0].label, nil
+		default:
+			return nil, "", fmt.Errorf("Several keyring collections look hardware-backed (%v); pick one by label with --keyring-collection", collectionLabels(hardware))
+		}
+	}
+
+	for _, c := range collections {
+		if strings.EqualFold(c.label, label) {
+			return collectionKeyring{path: c.path}, c.label, nil
+		}
+	}
+	return nil, "", fmt.Errorf("No keyring collection labelled %q (available: %v)", label, collectionLabels(collections))
+}
+
+func collectionLabels(collections []secretCollection) string {
+	labels := make([]string, len(collections))
+	for i, c := range collections {
+		labels[i] = fmt.Sprintf("%q", c.label)
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, ", ")
+}
+
+// collectionKeyring is the Secret Service backend for one collection other
+// than the default, storing items with the attributes go-keyring uses.
+type collectionKeyring struct {
+	path dbus.ObjectPath
+}
+
+func (k collectionKeyring) open() (*ss.SecretService, dbus.BusObject, error) {
+	svc, err := ss.NewSecretService()
+	if err != nil {
+		return nil, nil, err
+	}
+	collection := svc.Object("org.freedesktop.secrets", k.path)
+	if err := svc.Unlock(k.path); err != nil {
+		return nil, nil, err
+	}
+	return svc, collection, nil
+}
+
+func (k collectionKeyring) find(service string, attrs map[string]string) (*ss.SecretService, []dbus.ObjectPath, error) {
+	svc, collection, err := k.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	search := map[string]string{"service": service}
+	for key, value := range attrs {
+		search[key] = value
+	}
+	items, err := svc.SearchItems(collection, search)
+	if err != nil {
+		return nil, nil, err
+	}
+	return svc, items, nil
+}
+
+func (k collectionKeyring) Set(service, user, password string) error {
+	svc, collection, err := k.open()
+	if err != nil {
+		return err
+	}
+	session, err := svc.OpenSession()
+	if err != nil {
+		return err
+	}
+	defer svc.Close(session)
+
+	return svc.CreateItem(collection,
+		fmt.Sprintf("Password for '%s' on '%s'", user, service),
+		map[string]string{"username": user, "service": service},
+		ss.NewSecret(session.Path(), password))
+}
+
+func (k collectionKeyring) Get(service, user string) (string, error) {
+	svc, items, err := k.find(service, map[string]string{"username": user})
+	if err != nil {
+		return "", err
+	}
+	if len(items) == 0 {
+		return "", keyring.ErrNotFound
+	}
+	session, err := svc.OpenSession()
+	if err != nil {
+		return "", err
+	}
+	defer svc.Close(session)
+
+	if err := svc.Unlock(items[0]); err != nil {
+		return "", err
+	}
+	secret, err := svc.GetSecret(items[0], session.Path())
+	if err != nil {
+		return "", err
+	}
+	return string(secret.Value), nil
+}
+
+func (k collectionKeyring) Delete(service, user string) error {
+	svc, items, err := k.find(service, map[string]string{"username": user})
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return keyring.ErrNotFound
+	}
+	return svc.Delete(items[0])
+}
+
+func (k collectionKeyring) DeleteAll(service string) error {
+	if service == "" {
+		return keyring.ErrNotFound
+	}
+	svc, items, err := k.find(service, nil)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := svc.Delete(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (k collectionKeyring) List(service string) ([]string, error) {
+	svc, items, err := k.find(service, nil)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, item := range items {
+		prop, err := svc.Object("org.freedesktop.secrets", item).GetProperty("org.freedesktop.Secret.Item.Attributes")
+		if err != nil {
+			return nil, err
+		}
+		attrs, ok := prop.Value().(map[string]string)
+		if !ok {
+			continue
+		}
+		if user := attrs["username"]; user != "" {
+			names = append(names, user)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/zalando/go-keyring"
+)
+
+// fileKeyring keeps secrets in a plain JSON file readable only by the user.
+// It exists for headless machines without a keyring daemon; the secrets are
+// NOT encrypted at rest.
+type fileKeyring struct{}
+
+// fileKeyringData maps service to user to password.
+type fileKeyringData map[string]map[string]string
+
+func fileKeyringPath() (string, error) {
+	home, err := homeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".totp-secrets.json"), nil
+}
+
+func readFileKeyring() (fileKeyringData, error) {
+	path, err := fileKeyringPath()
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fileKeyringData{}, nil
+		}
+		return nil, err
+	}
+
+	data := fileKeyringData{}
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func writeFileKeyring(data fileKeyringData) error {
+	path, err := fileKeyringPath()
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	return writeFileAtomic(path, b)
+}
+
+// updateFileKeyring applies fn to the file keyring and writes the result
+// back, holding a lock next to the file so that concurrent totp processes
+// cannot lose each other's changes.
+func updateFileKeyring(fn func(data fileKeyringData) error) error {
+	path, err := fileKeyringPath()
+	if err != nil {
+		return err
+	}
+	if err := acquireLock(path + ".lock"); err != nil {
+		return err
+	}
+	defer os.Remove(path + ".lock")
+
+	data, err := readFileKeyring()
+	if err != nil {
+		return err
+	}
+	if err := fn(data); err != nil {
+		return err
+	}
+	return writeFileKeyring(data)
+}
+
+func (fileKeyring) Set(service, user, password string) error {
+	return updateFileKeyring(func(data fileKeyringData) error {
+		if data[service] == nil {
+			data[service] = map[string]string{}
+		}
+		data[service][user] = password
+		return nil
+	})
+}
+
+func (fileKeyring) Get(service, user string) (string, error) {
+	data, err := readFileKeyring()
+	if err != nil {
+		return "", err
+	}
+
+	password, ok := data[service][user]
+	if !ok {
+		return "", keyring.ErrNotFound
+	}
+	return password, nil
+}
+
+func (fileKeyring) Delete(service, user string) error {
+	return updateFileKeyring(func(data fileKeyringData) error {
+		if _, ok := data[service][user]; !ok {
+			return keyring.ErrNotFound
+		}
+		delete(data[service], user)
+		return nil
+	})
+}
+
+func (fileKeyring) DeleteAll(service string) error {
+	return updateFileKeyring(func(data fileKeyringData) error {
+		delete(data, service)
+		return nil
+	})
+}
+
+func (fileKeyring) List(service string) ([]string, error) {
+	data, err := readFileKeyring()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for user := range data[service] {
+		names = append(names, user)
+	}
+	sort.Strings(names)
+	return names, nil
+}
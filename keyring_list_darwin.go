@@ -0,0 +1,50 @@
+//go:build darwin
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// listSystemKeyring enumerates the accounts stored under service by parsing
+// the output of `security dump-keychain`, which lists item attributes without
+// their secrets.
+func listSystemKeyring(service string) ([]string, error) {
+	out, err := exec.Command("/usr/bin/security", "dump-keychain").Output()
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	var acct, svce string
+	flush := func() {
+		if svce == service && acct != "" {
+			names = append(names, acct)
+		}
+		acct, svce = "", ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "keychain:"):
+			flush()
+		case strings.HasPrefix(line, `"acct"<blob>="`):
+			acct = strings.TrimSuffix(strings.TrimPrefix(line, `"acct"<blob>="`), `"`)
+		case strings.HasPrefix(line, `"svce"<blob>="`):
+			svce = strings.TrimSuffix(strings.TrimPrefix(line, `"svce"<blob>="`), `"`)
+		}
+	}
+	flush()
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
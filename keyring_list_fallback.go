@@ -0,0 +1,7 @@
+//go:build !darwin && !windows && !((dragonfly && cgo) || (freebsd && cgo) || linux || netbsd || openbsd)
+
+package main
+
+func listSystemKeyring(service string) ([]string, error) {
+	return nil, errListUnsupported
+}
@@ -0,0 +1,45 @@
+//go:build (dragonfly && cgo) || (freebsd && cgo) || linux || netbsd || openbsd
+
+package main
+
+import (
+	"sort"
+
+	ss "github.com/zalando/go-keyring/secret_service"
+)
+
+// listSystemKeyring enumerates the users stored under service in the default
+// Secret Service collection, using the same attributes go-keyring writes.
+func listSystemKeyring(service string) ([]string, error) {
+	svc, err := ss.NewSecretService()
+	if err != nil {
+		return nil, err
+	}
+
+	collection := svc.GetLoginCollection()
+	if err := svc.Unlock(collection.Path()); err != nil {
+		return nil, err
+	}
+
+	items, err := svc.SearchItems(collection, map[string]string{"service": service})
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, item := range items {
+		prop, err := svc.Object("org.freedesktop.secrets", item).GetProperty("org.freedesktop.Secret.Item.Attributes")
+		if err != nil {
+			return nil, err
+		}
+		attrs, ok := prop.Value().(map[string]string)
+		if !ok {
+			continue
+		}
+		if user := attrs["username"]; user != "" {
+			names = append(names, user)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/danieljoos/wincred"
+)
+
+// listSystemKeyring enumerates the users stored under service. go-keyring
+// names Windows credentials "<service>:<user>".
+func listSystemKeyring(service string) ([]string, error) {
+	creds, err := wincred.List()
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := service + ":"
+	var names []string
+	for _, cred := range creds {
+		if strings.HasPrefix(cred.TargetName, prefix) {
+			names = append(names, strings.TrimPrefix(cred.TargetName, prefix))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestIsHardwareCollectionLabel(t *testing.T) {
+	tests := []struct {
+		label string
+		want  bool
+	}{
+		{"YubiKey", true},
+		{"My YubiKey 5", true},
+		{"keepassxc (yubikey)", true},
+		{"PIV", true},
+		{"Nitrokey-Start", true},
+		{"Smart Card", true},
+		{"TPM", true},
+		{"Hardware tokens", true},
+		{"Login", false},
+		{"Default keyring", false},
+		{"tpmfoo", false},
+		{"Pivotal", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isHardwareCollectionLabel(tt.label); got != tt.want {
+			t.Errorf("isHardwareCollectionLabel(%q) = %v, want %v", tt.label, got, tt.want)
+		}
+	}
+}
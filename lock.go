@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	lockTimeout = 5 * time.Second
+	lockStale   = 30 * time.Second
+	lockPoll    = 50 * time.Millisecond
+)
+
+func indexLockPath() (string, error) {
+	path, err := indexFilePath()
+	if err != nil {
+		return "", err
+	}
+	return path + ".lock", nil
+}
+
+// withIndexLock runs fn while holding an exclusive lock next to the index
+// file, so concurrent totp processes cannot interleave read-modify-write
+// cycles. In read-only mode no lock is taken, since nothing is written.
+// Taking the lock again while it is held just runs fn.
+func withIndexLock(fn func() error) error {
+	if readOnly || indexLockHeld {
+		return fn()
+	}
+	path, err := indexLockPath()
+	if err != nil {
+		return err
+	}
+
+	if err := acquireLock(path); err != nil {
+		if fallBackToReadOnly(err) {
+			return fn()
+		}
+		return err
+	}
+	defer os.Remove(path)
+
+	indexLockHeld = true
+	defer func() { indexLockHeld = false }()
+	return fn()
+}
+
+// acquireLock creates the lock file at path, waiting up to lockTimeout for
+// another process to remove it. The lock is a plain file created with
+// O_EXCL, which works on every platform; locks older than lockStale are
+// assumed abandoned and broken. The caller removes path to unlock.
+func acquireLock(path string) error {
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			fmt.Fprintln(f, strconv.Itoa(os.Getpid()))
+			f.Close()
+			return nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockStale {
+			os.Remove(path)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for lock %v; remove it if no other totp is running", path)
+		}
+		time.Sleep(lockPoll)
+	}
+}
+
+// indexLockHeld is set while this process holds the index lock, so that code
+// running under it can take it again.
+var indexLockHeld bool
+
+// updateIndex applies fn to the index under the index lock and writes the
+// result back.
+func updateIndex(fn func(idx *indexFile) error) error {
+	return withIndexLock(func() error {
+		idx, err := readIndex()
+		if err != nil {
+			return err
+		}
+		if err := fn(&idx); err != nil {
+			return err
+		}
+		return writeIndex(idx)
+	})
+}
+
+// writeFileAtomic replaces the file at path with data, so that readers see
+// either the old or the new contents but never a partial write. Through a
+// symlink, the file it points to is replaced.
+func writeFileAtomic(path string, data []byte) error {
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
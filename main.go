@@ -7,36 +7,121 @@ import (
 	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
+	"io"
+	"io/fs"
+	"math"
+	"net/http"
 	"net/url"
 	"os"
 
 	"bufio"
+	"bytes"
+	"crypto/sha256"
 	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"path"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/atotto/clipboard"
 	"github.com/makiuchi-d/gozxing"
-	"github.com/makiuchi-d/gozxing/qrcode"
 	"github.com/spf13/cobra"
-	"github.com/xlzd/gotp"
 	"github.com/zalando/go-keyring"
+	_ "golang.org/x/image/bmp"
+	"golang.org/x/term"
 )
 
-const serviceName = "totp"
+// serviceName is the keyring service entries are stored under. Profiles
+// other than the default one change it.
+var serviceName = "totp"
+
+// ignoreCase makes name lookups match index names case-insensitively.
+var ignoreCase bool
+
+// lenientSecrets makes secret normalization also strip dashes, dots,
+// underscores, tabs and padding, not just spaces.
+var lenientSecrets bool
+
+// readOnly suppresses every write to the index and its lock file, for
+// read-only home directories. It is also switched on, with a warning, the
+// first time such a write fails because the file system refuses it.
+var readOnly bool
+
+// prepareCompletion applies the configuration file and global flags (profile,
+// keyring backend, index format and order) for shell completion. Cobra runs
+// PersistentPreRunE for its __complete command before the flags of the line
+// being completed are parsed, so completion functions call this instead once
+// they are. Completion never writes the index: it is run read-only.
+var prepareCompletion = func() error { return nil }
+
+// quiet suppresses informational output (see infof) and notes on stderr;
+// data, warnings and errors are still printed.
+var quiet bool
+
+// verbose keeps informational output on stdout when it is not a terminal.
+var verbose bool
+
+// homeOverride replaces the user's home directory as the base of every file
+// totp keeps (index, lock and file keyring), when set.
+var homeOverride string
+
+// homeDir returns the directory totp's files live in: homeOverride, or the
+// user's home directory.
+func homeDir() (string, error) {
+	if homeOverride != "" {
+		return homeOverride, nil
+	}
+	return os.UserHomeDir()
+}
 
 type indexFile struct {
-	Names []string `json:"names"`
+	Names []string `json:"names" yaml:"names" toml:"names"`
+	// Collection is the label of the Secret Service collection the entries
+	// are kept in, as last chosen with --keyring-collection; empty for the
+	// default collection.
+	Collection string                `json:"collection,omitempty" yaml:"collection,omitempty" toml:"collection,omitempty"`
+	Entries    map[string]indexEntry `json:"entries,omitempty" yaml:"entries,omitempty" toml:"entries,omitempty"`
+}
+
+// indexEntry is the non-secret metadata mirrored into the index so that
+// completion can offer it without reading the keyring.
+type indexEntry struct {
+	Issuer   string   `json:"issuer,omitempty" yaml:"issuer,omitempty" toml:"issuer,omitempty"`
+	Tags     []string `json:"tags,omitempty" yaml:"tags,omitempty" toml:"tags,omitempty"`
+	Created  int64    `json:"created,omitempty" yaml:"created,omitempty" toml:"created,omitzero"`       // Unix time the entry was added
+	Modified int64    `json:"modified,omitempty" yaml:"modified,omitempty" toml:"modified,omitzero"`    // Unix time the entry was last stored or renamed
+	LastUsed int64    `json:"last_used,omitempty" yaml:"last_used,omitempty" toml:"last_used,omitzero"` // Unix time of the last `get`
+
+	// RotateAfter is the advisory max age of the secret, in seconds.
+	RotateAfter int64 `json:"rotate_after,omitempty" yaml:"rotate_after,omitempty" toml:"rotate_after,omitzero"`
+	// Rotated is the Unix time the secret was last replaced by
+	// `totp confirm-rotation`; the secret's age counts from it.
+	Rotated int64 `json:"rotated,omitempty" yaml:"rotated,omitempty" toml:"rotated,omitzero"`
 }
 
 func indexFilePath() (string, error) {
-	home, err := os.UserHomeDir()
+	if profileIndexPath != "" {
+		if err := checkIndexPathFormat(profileIndexPath); err != nil {
+			return "", err
+		}
+		return profileIndexPath, nil
+	}
+	home, err := homeDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(home, ".totp.json"), nil
+	return filepath.Join(home, ".totp"+indexExt()), nil
 }
 
 func readIndex() (indexFile, error) {
@@ -48,421 +133,3979 @@ func readIndex() (indexFile, error) {
 	b, err := os.ReadFile(path)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return indexFile{}, nil
+			return readJSONIndexBeside(path), nil
 		}
 		return indexFile{}, err
 	}
 
 	var idx indexFile
-	if err := json.Unmarshal(b, &idx); err != nil {
-		return indexFile{}, err
+	if parseErr := indexCodecFor(path).unmarshal(b, &idx); parseErr != nil {
+		// A single bad write must not brick every command: set the file
+		// aside and carry on with an empty index.
+		if readOnly {
+			fmt.Fprintf(os.Stderr, "Warning: index %v is corrupt (%v); ignoring it.\n", path, parseErr)
+			return indexFile{}, nil
+		}
+		idx, backup, err := setAsideCorruptIndex(path)
+		if err != nil {
+			return indexFile{}, err
+		}
+		if backup != "" {
+			fmt.Fprintf(os.Stderr, "Warning: index %v is corrupt (%v); moved it to %v and started a new one. Run `totp list --no-index` to rebuild it from the keyring.\n", path, parseErr, backup)
+		}
+		return idx, nil
 	}
 	return idx, nil
 }
 
+// setAsideCorruptIndex moves the index at path to a free backup name. It
+// does so under the index lock, and only if the file still does not parse
+// there: a concurrent writer may have replaced it meanwhile. It returns the
+// index to carry on with and the backup's path, or "" if nothing was moved.
+func setAsideCorruptIndex(path string) (idx indexFile, backup string, err error) {
+	err = withIndexLock(func() error {
+		b, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if indexCodecFor(path).unmarshal(b, &idx) == nil {
+			return nil
+		}
+		idx = indexFile{}
+		if readOnly {
+			return nil
+		}
+
+		backup = freeBackupPath(path)
+		if err := os.Rename(path, backup); err != nil {
+			backup = ""
+			return fmt.Errorf("index %v is corrupt and could not be moved aside: %w", path, err)
+		}
+		return nil
+	})
+	return idx, backup, err
+}
+
+// freeBackupPath returns path + ".bak", or ".bak.1", ".bak.2" and so on when
+// that exists, so that an earlier backup is never overwritten.
+func freeBackupPath(path string) string {
+	backup := path + ".bak"
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(backup); errors.Is(err, os.ErrNotExist) {
+			return backup
+		}
+		backup = fmt.Sprintf("%v.bak.%d", path, i)
+	}
+}
+
+// readJSONIndexBeside returns the JSON index next to a YAML or TOML index
+// that does not exist yet, such as ~/.totp.json for ~/.totp.toml, so that
+// switching formats keeps the names. The next write saves them in the new
+// format. Anything unreadable is an empty index, as before the switch.
+func readJSONIndexBeside(path string) indexFile {
+	ext := filepath.Ext(path)
+	if ext == ".json" {
+		return indexFile{}
+	}
+	var idx indexFile
+	if b, err := os.ReadFile(strings.TrimSuffix(path, ext) + ".json"); err == nil {
+		if json.Unmarshal(b, &idx) != nil {
+			return indexFile{}
+		}
+	}
+	return idx
+}
+
 func writeIndex(idx indexFile) error {
+	if readOnly {
+		return nil
+	}
 	path, err := indexFilePath()
 	if err != nil {
 		return err
 	}
 
-	sort.Strings(idx.Names)
-	b, err := json.MarshalIndent(idx, "", "  ")
+	sortIndexNames(idx.Names)
+	b, err := indexCodecFor(path).marshal(idx)
 	if err != nil {
 		return err
 	}
-	b = append(b, '\n')
-	return os.WriteFile(path, b, 0o600)
+	if err := writeFileAtomic(path, b); err != nil {
+		if fallBackToReadOnly(err) {
+			return nil
+		}
+		return err
+	}
+	return nil
 }
 
-func addNameToIndex(name string) error {
-	idx, err := readIndex()
-	if err != nil {
-		return err
+// fallBackToReadOnly reports whether err means the index directory cannot be
+// written at all. If so it switches to read-only mode for the rest of the
+// run, warning once, so reading commands keep working.
+func fallBackToReadOnly(err error) bool {
+	if !errors.Is(err, syscall.EROFS) && !errors.Is(err, fs.ErrPermission) {
+		return false
 	}
+	fmt.Fprintf(os.Stderr, "Warning: cannot write the index (%v); continuing read-only. Pass --read-only to silence this.\n", err)
+	readOnly = true
+	return true
+}
 
-	for _, n := range idx.Names {
-		if n == name {
-			return nil
+func addNameToIndex(name string, entry indexEntry) error {
+	return updateIndex(func(idx *indexFile) error {
+		found := false
+		for _, n := range idx.Names {
+			if n == name {
+				found = true
+				break
+			}
 		}
-	}
-	idx.Names = append(idx.Names, name)
-	return writeIndex(idx)
+		if !found {
+			idx.Names = append(idx.Names, name)
+		}
+		if idx.Entries == nil {
+			idx.Entries = map[string]indexEntry{}
+		}
+		prev := idx.Entries[name]
+		entry.Created, entry.LastUsed = prev.Created, prev.LastUsed
+		if entry.RotateAfter == 0 {
+			entry.RotateAfter = prev.RotateAfter
+		}
+		if entry.Rotated == 0 {
+			entry.Rotated = prev.Rotated
+		}
+		entry.Modified = time.Now().Unix()
+		if entry.Created == 0 {
+			entry.Created = entry.Modified
+		}
+		idx.Entries[name] = entry
+		return nil
+	})
+}
+
+// recordUse stamps name as used now in the index, for `list --sort recent`.
+func recordUse(name string) error {
+	return updateIndex(func(idx *indexFile) error {
+		if idx.Entries == nil {
+			idx.Entries = map[string]indexEntry{}
+		}
+		entry := idx.Entries[name]
+		entry.LastUsed = time.Now().Unix()
+		idx.Entries[name] = entry
+		return nil
+	})
+}
+
+// setRotateAfter records the advisory max age of name's secret in the index.
+func setRotateAfter(name string, maxAge time.Duration) error {
+	return updateIndex(func(idx *indexFile) error {
+		if idx.Entries == nil {
+			idx.Entries = map[string]indexEntry{}
+		}
+		entry := idx.Entries[name]
+		entry.RotateAfter = int64(maxAge / time.Second)
+		idx.Entries[name] = entry
+		return nil
+	})
 }
 
 func removeNameFromIndex(name string) error {
+	return removeNamesFromIndex(map[string]bool{name: true})
+}
+
+func removeNamesFromIndex(names map[string]bool) error {
+	return updateIndex(func(idx *indexFile) error {
+		out := idx.Names[:0]
+		for _, n := range idx.Names {
+			if !names[n] {
+				out = append(out, n)
+			}
+		}
+		idx.Names = out
+		for n := range names {
+			delete(idx.Entries, n)
+		}
+		return nil
+	})
+}
+
+// resolveName maps name to the canonical name stored in the index. Unless
+// ignoreCase is set, or no case-insensitive match exists, name is returned as is.
+func resolveName(name string) (string, error) {
+	if !ignoreCase {
+		return name, nil
+	}
+
 	idx, err := readIndex()
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	out := idx.Names[:0]
 	for _, n := range idx.Names {
-		if n != name {
-			out = append(out, n)
+		if n == name {
+			return n, nil
+		}
+	}
+	for _, n := range idx.Names {
+		if strings.EqualFold(n, name) {
+			return n, nil
 		}
 	}
-	idx.Names = out
-	return writeIndex(idx)
+	return name, nil
 }
 
+// lenientSecretReplacer strips the separators some services use to group
+// secrets (e.g. "JBSW-Y3DP-EHPK"), plus any Base32 padding.
+var lenientSecretReplacer = strings.NewReplacer(
+	"-", "",
+	"_", "",
+	".", "",
+	"\t", "",
+	"=", "",
+)
+
 func normalizeAndValidateSecret(secret string) (string, error) {
 	normalized := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(secret), " ", ""))
+	if lenientSecrets {
+		normalized = lenientSecretReplacer.Replace(normalized)
+	}
 	if normalized == "" {
-		return "", errors.New("No secret was given")
+		return "", fmt.Errorf("%w: no secret was given", ErrInvalidSecret)
 	}
 	if _, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(normalized); err != nil {
-		return "", errors.New("Invalid secret (expected Base32)")
+		if !lenientSecrets && lenientSecretReplacer.Replace(normalized) != normalized {
+			return "", fmt.Errorf("%w (expected Base32; use --lenient to ignore separators)", ErrInvalidSecret)
+		}
+		return "", fmt.Errorf("%w (expected Base32)", ErrInvalidSecret)
 	}
 	return normalized, nil
 }
 
-func addItem(name, secret string) error {
-	if err := keyring.Set(serviceName, name, secret); err != nil {
+func addItem(name string, a account) error {
+	value, err := encodeAccount(a)
+	if err != nil {
+		return err
+	}
+	if err := keyringSet(name, value); err != nil {
 		if errors.Is(err, keyring.ErrSetDataTooBig) {
 			return fmt.Errorf("secret too large to store in system keyring: %w", err)
 		}
 		return err
 	}
-	return addNameToIndex(name)
+	return addNameToIndex(name, indexEntry{Issuer: a.Issuer, Tags: a.Tags})
+}
+
+// appendBackup stores secret as an additional (backup) secret of the
+// existing TOTP entry name.
+func appendBackup(name, secret string) error {
+	a, err := getItem(name)
+	if err != nil {
+		return err
+	}
+	if a.Type == accountTypeHOTP {
+		return errors.New("Backup secrets are only supported for TOTP entries")
+	}
+	if a.Protected != nil {
+		return errors.New("Backup secrets cannot be added to protected entries")
+	}
+	if secret == a.Secret || slices.Contains(a.Backups, secret) {
+		return errors.New("Given secret is already stored under this name")
+	}
+	a.Backups = append(a.Backups, secret)
+	return addItem(name, a)
+}
+
+// isTerminal reports whether f is an interactive terminal rather than a
+// pipe or file.
+func isTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// quietInfo reports whether informational output on stdout is suppressed:
+// with --quiet, and by default when stdout is not a terminal, where it would
+// only get in the way of scripts. --verbose keeps it.
+func quietInfo() bool {
+	return quiet || (!verbose && !isTerminal(os.Stdout))
+}
+
+// infof prints an informational message, such as a confirmation that
+// something was done, to stdout unless quietInfo says otherwise. Data
+// (codes, names, URIs) is always printed with fmt directly.
+func infof(format string, args ...any) {
+	if !quietInfo() {
+		fmt.Printf(format, args...)
+	}
+}
+
+// printData prints value, a piece of data such as a code, on stdout. Its
+// label is informational and left out as infof output is, so scripts get the
+// bare value.
+func printData(label string, value any) {
+	infof("%v: ", label)
+	fmt.Println(value)
+}
+
+// maskCode hides every digit of code, for --mask.
+func maskCode(code string) string {
+	return strings.Repeat("*", len(code))
+}
+
+const (
+	truncateRight = "right"
+	truncateLeft  = "left"
+)
+
+// truncateCode keeps n characters of code, from the right or left end as
+// from says, for providers that only accept part of the standard code.
+func truncateCode(code string, n int, from string) (string, error) {
+	if n < 1 || n > len(code) {
+		return "", fmt.Errorf("--truncate-to must be between 1 and the code length, %d", len(code))
+	}
+	if from == truncateLeft {
+		return code[:n], nil
+	}
+	return code[len(code)-n:], nil
 }
 
-func outputCode(code string, copyToClipboard bool) error {
+// outputCode prints code, or copies it to the clipboard and prints it
+// partially masked (nothing if quietInfo). With mask, no digit is ever
+// printed.
+func outputCode(code string, copyToClipboard, mask bool) error {
+	shown := code
+	if mask {
+		shown = maskCode(code)
+	}
 	if !copyToClipboard {
-		fmt.Println(code)
+		fmt.Println(shown)
 		return nil
 	}
 
 	if err := clipboard.WriteAll(code); err != nil {
-		fmt.Printf("%v (copy failed)\n", code)
+		fmt.Printf("%v (copy failed)\n", shown)
 		return nil
 	}
 
-	masked := code
-	if len(code) >= 2 {
-		masked = code[:2] + "****"
+	if !mask && len(code) >= 2 {
+		shown = code[:2] + "****"
 	}
-	fmt.Printf("%v (copied)\n", masked)
+	infof("%v (copied)\n", shown)
 	return nil
 }
 
-func getItem(name string) (string, error) {
-	secret, err := keyring.Get(serviceName, name)
+// codeInfo is the data available to `get --format` templates and printed by
+// `get --json`. The timing fields are zero for HOTP entries.
+type codeInfo struct {
+	Name       string `json:"name"`
+	Code       string `json:"code"`
+	ExpiresIn  int    `json:"expires_in,omitempty"`
+	Issuer     string `json:"issuer,omitempty"`
+	Account    string `json:"account,omitempty"`
+	Period     int    `json:"period,omitempty"`
+	ValidFrom  int64  `json:"valid_from,omitempty"`  // Unix time the code's step began
+	ValidUntil int64  `json:"valid_until,omitempty"` // Unix time the code's step ends
+
+	BackupCodes []string `json:"backup_codes,omitempty"` // codes of the backup secrets, if any
+	PendingCode string   `json:"pending_code,omitempty"` // code of the new secret while a rotation is pending
+
+	// pendingOverdue is set once a pending rotation has outlived its grace
+	// period, to remind the user to confirm it.
+	pendingOverdue bool
+
+	// secret is only ever printed by `get --json --include-secret`, so it is
+	// kept out of both the JSON and the templates.
+	secret string
+}
+
+// listRecord is one line of `list --json-lines` output. Code and ExpiresIn
+// are only set with --codes, and never for HOTP or locked entries. The
+// timestamps are Unix times from the index, omitted if never recorded.
+type listRecord struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Issuer    string   `json:"issuer,omitempty"`
+	Account   string   `json:"account,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	Locked    bool     `json:"locked,omitempty"`
+	Counter   *uint64  `json:"counter,omitempty"` // HOTP entries only
+	Code      string   `json:"code,omitempty"`
+	ExpiresIn int      `json:"expires_in,omitempty"`
+	Created   int64    `json:"created,omitempty"`
+	Modified  int64    `json:"modified,omitempty"`
+	LastUsed  int64    `json:"last_used,omitempty"`
+
+	Source *scanOrigin `json:"source,omitempty"`
+}
+
+// parseCodeTemplate parses a `get --format` template and dry-runs it so that
+// unknown fields are reported before touching the keyring.
+func parseCodeTemplate(format string) (*template.Template, error) {
+	tmpl, err := template.New("format").Parse(format)
 	if err != nil {
-		if errors.Is(err, keyring.ErrNotFound) {
-			return "", errors.New("Given name is not found")
+		return nil, fmt.Errorf("invalid --format: %w", err)
+	}
+	if err := tmpl.Execute(io.Discard, codeInfo{}); err != nil {
+		return nil, fmt.Errorf("invalid --format: %w", err)
+	}
+	return tmpl, nil
+}
+
+// currentCode looks up name and generates its code at now.
+func currentCode(name string, now time.Time) (codeInfo, error) {
+	name, err := resolveName(name)
+	if err != nil {
+		return codeInfo{}, err
+	}
+
+	a, err := getUnlockedItem(name)
+	if err != nil {
+		return codeInfo{}, err
+	}
+	if a.Type == accountTypeHOTP {
+		code, err := nextHOTPCode(name, a.Secret)
+		if err != nil {
+			return codeInfo{}, err
 		}
-		return "", err
+		return codeInfo{Name: name, Code: code, Issuer: a.Issuer, Account: a.Account, secret: a.Secret}, nil
 	}
-	return secret, nil
+	return totpCodeAt(name, a, accountTime(a, now))
 }
 
-func deleteItem(name string) error {
-	err := keyring.Delete(serviceName, name)
-	if err != nil && !errors.Is(err, keyring.ErrNotFound) {
+// statusbarCode is currentCode for get --statusbar, which a status bar polls
+// and so must never block. It refuses protected entries rather than ask for
+// a passphrase, and HOTP entries since each poll would use up a code. An
+// entry's time source is not consulted: that may take seconds.
+func statusbarCode(name string, now time.Time) (codeInfo, error) {
+	name, err := resolveName(name)
+	if err != nil {
+		return codeInfo{}, err
+	}
+
+	a, err := getItem(name)
+	if err != nil {
+		return codeInfo{}, err
+	}
+	if a.Protected != nil {
+		return codeInfo{}, errors.New("--statusbar does not work with passphrase-protected entries")
+	}
+	if a.Type == accountTypeHOTP {
+		return codeInfo{}, errors.New("--statusbar only works with TOTP entries")
+	}
+	return totpCodeAt(name, a, now)
+}
+
+// verifyCode checks code against the TOTP account a within window steps
+// either side of now, for verify and get --verify-against, and reports the
+// step it matches.
+func verifyCode(a account, code string, now time.Time, window int) error {
+	offset, ok, err := a.matchOffset(code, now, window)
+	if err != nil {
 		return err
 	}
-	return removeNameFromIndex(name)
+	if !ok {
+		return fmt.Errorf("Code does not match within ±%v steps (±%vs)", window, window*a.Period)
+	}
+	fmt.Printf("Code %v.\n", describeOffset(offset, a.Period))
+	return nil
 }
 
-func listItems() ([]string, error) {
-	idx, err := readIndex()
+// describeOffset describes the step offset a code matched at.
+func describeOffset(offset, period int) string {
+	if offset == 0 {
+		return "matches the current step (offset 0, time delta 0s)"
+	}
+	direction := "behind"
+	if offset > 0 {
+		direction = "ahead of"
+	}
+	return fmt.Sprintf("matches step offset %+d (time delta %+ds): the clock that produced it is %v this one", offset, offset*period, direction)
+}
+
+// totpCodeAt is currentCode for the TOTP account a at time t, which need not
+// be now.
+func totpCodeAt(name string, a account, t time.Time) (codeInfo, error) {
+	code, err := a.code(t)
 	if err != nil {
-		return nil, err
+		return codeInfo{}, err
+	}
+	backups, err := a.backupCodes(t)
+	if err != nil {
+		return codeInfo{}, err
+	}
+	pendingCode, err := a.pendingCode(t)
+	if err != nil {
+		return codeInfo{}, err
 	}
+	validFrom := a.stepStart(t)
+	return codeInfo{
+		Name:       name,
+		Code:       code,
+		ExpiresIn:  a.expiresIn(t),
+		Issuer:     a.Issuer,
+		Account:    a.Account,
+		Period:     a.Period,
+		ValidFrom:  validFrom,
+		ValidUntil: validFrom + int64(a.Period),
 
-	var kept []string
-	for _, name := range idx.Names {
-		_, err := keyring.Get(serviceName, name)
-		if err == nil {
-			kept = append(kept, name)
-			continue
+		BackupCodes: backups,
+		PendingCode: pendingCode,
+		secret:      a.Secret,
+
+		pendingOverdue: a.Pending != nil && a.Pending.overdue(time.Now()),
+	}, nil
+}
+
+// offsetStepCode returns the code of the TOTP entry name steps time steps
+// away from now, noting the step and its validity window on stderr.
+func offsetStepCode(name string, steps int, now time.Time) (codeInfo, error) {
+	name, err := resolveName(name)
+	if err != nil {
+		return codeInfo{}, err
+	}
+	a, err := getUnlockedItem(name)
+	if err != nil {
+		return codeInfo{}, err
+	}
+	if a.Type == accountTypeHOTP {
+		return codeInfo{}, errors.New("--offset-step only works with TOTP entries")
+	}
+
+	now = accountTime(a, now)
+	info, err := totpCodeAt(name, a, now.Add(time.Duration(steps*a.Period)*time.Second))
+	if err != nil {
+		return codeInfo{}, err
+	}
+	// Count down to the end of that step from now, not from within it; past
+	// steps have already expired.
+	info.ExpiresIn = int(info.ValidUntil - now.Unix())
+	if !quiet {
+		fmt.Fprintf(os.Stderr, "Step %+d: valid %v to %v\n", steps,
+			time.Unix(info.ValidFrom, 0).Format(time.TimeOnly), time.Unix(info.ValidUntil, 0).Format(time.TimeOnly))
+	}
+	return info, nil
+}
+
+// freshCode returns the code of the TOTP entry name, first waiting for the
+// next time step if fewer than minRemaining seconds of the current code are
+// left, so the code returned stays valid for longer.
+func freshCode(name string, minRemaining int) (codeInfo, error) {
+	name, err := resolveName(name)
+	if err != nil {
+		return codeInfo{}, err
+	}
+	a, err := getUnlockedItem(name)
+	if err != nil {
+		return codeInfo{}, err
+	}
+	if a.Type == accountTypeHOTP {
+		return codeInfo{}, errors.New("--wait only works with TOTP entries")
+	}
+
+	now := accountTime(a, time.Now())
+	if a.expiresIn(now) < minRemaining {
+		next := time.Unix(a.stepStart(now)+int64(a.Period), 0)
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "Waiting %v for a fresh code...\n", next.Sub(now).Round(time.Second))
 		}
+		time.Sleep(next.Sub(now))
+		if now = accountTime(a, time.Now()); now.Before(next) {
+			now = next
+		}
+	}
+	return totpCodeAt(name, a, now)
+}
+
+// getItem reads the account stored under name. Legacy entries are upgraded
+// to the current format and written back on a best-effort basis.
+func getItem(name string) (account, error) {
+	name, err := resolveName(name)
+	if err != nil {
+		return account{}, err
+	}
+
+	value, err := keyringGet(name)
+	if err != nil {
 		if errors.Is(err, keyring.ErrNotFound) {
-			continue
+			return account{}, ErrNameNotFound
 		}
-		return nil, err
+		return account{}, err
 	}
-	idx.Names = kept
-	if err := writeIndex(idx); err != nil {
-		return nil, err
+
+	a, err := decodeAccount(value)
+	if err != nil {
+		return account{}, err
+	}
+	if upgradeAccount(&a) {
+		if value, err := encodeAccount(a); err == nil {
+			_ = keyringSet(name, value)
+		}
+	}
+	return a, nil
+}
+
+// nextHOTPCode generates the code for the current counter of the HOTP entry
+// name and stores the incremented counter. The read-increment-write runs under
+// the index lock so two processes never hand out the same counter, and the
+// code is only returned once the new counter has been persisted. secret is
+// the plaintext secret, which callers unlock beforehand for protected entries
+// so the passphrase prompt does not hold the lock.
+func nextHOTPCode(name, secret string) (string, error) {
+	name, err := resolveName(name)
+	if err != nil {
+		return "", err
+	}
+
+	var code string
+	err = withIndexLock(func() error {
+		a, err := getItem(name)
+		if err != nil {
+			return err
+		}
+
+		unlocked := a
+		unlocked.Secret = secret
+		code, err = unlocked.hotpCode()
+		if err != nil {
+			return err
+		}
+
+		a.Counter++
+		value, err := encodeAccount(a)
+		if err != nil {
+			return err
+		}
+		return keyringSet(name, value)
+	})
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// setHOTPCounter sets the counter of the HOTP entry name, e.g. to resync it
+// with the server. Like nextHOTPCode it runs under the index lock.
+func setHOTPCounter(name string, counter uint64) error {
+	name, err := resolveName(name)
+	if err != nil {
+		return err
+	}
+
+	return withIndexLock(func() error {
+		a, err := getItem(name)
+		if err != nil {
+			return err
+		}
+		if a.Type != accountTypeHOTP {
+			return fmt.Errorf("\"%v\" is not an HOTP entry", name)
+		}
+
+		a.Counter = counter
+		value, err := encodeAccount(a)
+		if err != nil {
+			return err
+		}
+		return keyringSet(name, value)
+	})
+}
+
+// getUnlockedItem is getItem for callers that need the plaintext secret. For
+// protected entries it prompts for the passphrase and decrypts the secret.
+func getUnlockedItem(name string) (account, error) {
+	a, err := getItem(name)
+	if err != nil {
+		return account{}, err
+	}
+	if a.Protected == nil {
+		return a, nil
+	}
+
+	passphrase, err := readPassphrase(fmt.Sprintf("Passphrase for \"%v\": ", name))
+	if err != nil {
+		return account{}, err
 	}
+	defer wipe(passphrase)
+
+	a.Secret, err = a.Protected.open(passphrase)
+	if err != nil {
+		return account{}, err
+	}
+	return a, nil
+}
+
+// migrateItem upgrades the entry stored under name to the current format,
+// reporting whether it had to be rewritten.
+func migrateItem(name string) (bool, error) {
+	value, err := keyringGet(name)
+	if err != nil {
+		return false, err
+	}
+
+	a, err := decodeAccount(value)
+	if err != nil {
+		return false, err
+	}
+	if !upgradeAccount(&a) {
+		return false, nil
+	}
+
+	value, err = encodeAccount(a)
+	if err != nil {
+		return false, err
+	}
+	return true, keyringSet(name, value)
+}
+
+// deleteItem removes name from the keyring and the index. When the keyring
+// has no such entry, any stale index entry is still removed and
+// keyring.ErrNotFound is returned.
+func deleteItem(name string) error {
+	err := keyringDelete(name)
+	if err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return err
+	}
+	if ierr := removeNameFromIndex(name); ierr != nil {
+		return ierr
+	}
+	return err
+}
+
+// deleteFromIndex removes name from the index only, leaving any keyring
+// entry in place. It returns ErrNameNotFound if the index does not list it.
+func deleteFromIndex(name string) error {
+	return updateIndex(func(idx *indexFile) error {
+		if !slices.Contains(idx.Names, name) {
+			return ErrNameNotFound
+		}
+		idx.Names = slices.DeleteFunc(idx.Names, func(n string) bool { return n == name })
+		delete(idx.Entries, name)
+		return nil
+	})
+}
+
+// splitIndexNames checks every indexed name against the keyring, returning
+// the names that exist and those whose keyring entry is gone.
+func splitIndexNames() (kept []string, missing map[string]bool, err error) {
+	idx, err := readIndex()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	missing = map[string]bool{}
+	for _, name := range idx.Names {
+		_, err := keyringGet(name)
+		if err == nil {
+			kept = append(kept, name)
+			continue
+		}
+		if errors.Is(err, keyring.ErrNotFound) {
+			missing[name] = true
+			continue
+		}
+		return nil, nil, err
+	}
+	return kept, missing, nil
+}
+
+func listItems() ([]string, error) {
+	kept, missing, err := splitIndexNames()
+	if err != nil {
+		return nil, err
+	}
+	if len(missing) > 0 {
+		if err := removeNamesFromIndex(missing); err != nil {
+			return nil, err
+		}
+	}
+
+	sortIndexNames(kept)
+	return kept, nil
+}
+
+// listIndexNames returns the indexed names without checking them against
+// the keyring, for callers that trust the index.
+func listIndexNames() ([]string, error) {
+	idx, err := readIndex()
+	if err != nil {
+		return nil, err
+	}
+	names := slices.Clone(idx.Names)
+	sortIndexNames(names)
+	return names, nil
+}
+
+var listSortOrders = []string{"name", "issuer", "recent", "created", "index"}
+
+// sortNames orders names for display using the metadata in the index:
+// alphabetically, by issuer, most recently used first, or oldest first. Ties
+// are broken by name. "index" leaves names in the order the index keeps them.
+// The stored order of the index is not affected.
+func sortNames(names []string, by string, idx indexFile) error {
+	var less func(a, b indexEntry) int
+	switch by {
+	case "index":
+		return nil
+	case "name":
+		less = func(a, b indexEntry) int { return 0 }
+	case "issuer":
+		less = func(a, b indexEntry) int {
+			// Entries without an issuer go last.
+			if (a.Issuer == "") != (b.Issuer == "") {
+				if a.Issuer == "" {
+					return 1
+				}
+				return -1
+			}
+			return strings.Compare(strings.ToLower(a.Issuer), strings.ToLower(b.Issuer))
+		}
+	case "recent":
+		less = func(a, b indexEntry) int { return compareInt64(b.LastUsed, a.LastUsed) }
+	case "created":
+		less = func(a, b indexEntry) int { return compareInt64(a.Created, b.Created) }
+	default:
+		return fmt.Errorf("unknown sort order %q (expected one of %v)", by, strings.Join(listSortOrders, ", "))
+	}
+
+	sort.SliceStable(names, func(i, j int) bool {
+		if c := less(idx.Entries[names[i]], idx.Entries[names[j]]); c != 0 {
+			return c < 0
+		}
+		return names[i] < names[j]
+	})
+	return nil
+}
+
+var listGroupings = []string{"issuer", "tag"}
+
+// nameGroup is one section of `list --group-by` output.
+type nameGroup struct {
+	title string
+	names []string
+}
+
+// groupNames splits names into sections by the issuer or tags in the index,
+// sorted alphabetically, followed by a section for entries without any. An
+// entry with several tags is listed under each. Names keep their order
+// within a section.
+func groupNames(names []string, by string, idx indexFile) ([]nameGroup, error) {
+	var keys func(e indexEntry) []string
+	var none string
+	switch by {
+	case "issuer":
+		keys = func(e indexEntry) []string {
+			if e.Issuer == "" {
+				return nil
+			}
+			return []string{e.Issuer}
+		}
+		none = "(no issuer)"
+	case "tag":
+		keys = func(e indexEntry) []string { return e.Tags }
+		none = "(no tags)"
+	default:
+		return nil, fmt.Errorf("unknown grouping %q (expected one of %v)", by, strings.Join(listGroupings, ", "))
+	}
+
+	members := map[string][]string{}
+	var ungrouped []string
+	for _, name := range names {
+		seen := map[string]bool{}
+		for _, key := range keys(idx.Entries[name]) {
+			if !seen[key] {
+				seen[key] = true
+				members[key] = append(members[key], name)
+			}
+		}
+		if len(seen) == 0 {
+			ungrouped = append(ungrouped, name)
+		}
+	}
+
+	titles := make([]string, 0, len(members))
+	for title := range members {
+		titles = append(titles, title)
+	}
+	sort.Slice(titles, func(i, j int) bool {
+		if c := strings.Compare(strings.ToLower(titles[i]), strings.ToLower(titles[j])); c != 0 {
+			return c < 0
+		}
+		return titles[i] < titles[j]
+	})
+	groups := make([]nameGroup, 0, len(titles)+1)
+	for _, title := range titles {
+		groups = append(groups, nameGroup{title, members[title]})
+	}
+	if len(ungrouped) != 0 {
+		groups = append(groups, nameGroup{none, ungrouped})
+	}
+	return groups, nil
+}
+
+// listCode returns the code list shows for a at now, as asterisks with
+// mask, and the seconds left until it changes. ok is false when there is no
+// code to show: a is locked, or HOTP, whose code would use up its counter.
+func listCode(a account, now time.Time, mask bool) (code string, expiresIn int, ok bool, err error) {
+	if a.Protected != nil || a.Type == accountTypeHOTP {
+		return "", 0, false, nil
+	}
+	at := accountTime(a, now)
+	if code, err = a.code(at); err != nil {
+		return "", 0, false, err
+	}
+	if mask {
+		code = maskCode(code)
+	}
+	return code, a.expiresIn(at), true, nil
+}
+
+// writeListRows writes a `list` table row for each name, prefixed with
+// indent, with the --long columns and the current code as asked. Listing must
+// not consume HOTP counters or prompt for passphrases, so neither gets a code.
+func writeListRows(w io.Writer, names []string, indent string, long, codes, mask bool, now time.Time) error {
+	for _, name := range names {
+		a, err := getItem(name)
+		if err != nil {
+			return err
+		}
+
+		row := []string{indent + name}
+		if long {
+			counter := "-"
+			if a.Type == accountTypeHOTP {
+				counter = strconv.FormatUint(a.Counter, 10)
+			}
+			source := "-"
+			if a.Source != nil {
+				source = a.Source.Path
+			}
+			row = append(row, a.Issuer, a.Account, strings.Join(a.Tags, ","), counter, source)
+		}
+		if codes {
+			code, _, ok, err := listCode(a, now, mask)
+			if err != nil {
+				return err
+			}
+			switch {
+			case a.Protected != nil:
+				code = "locked"
+			case !ok:
+				code = "-"
+			}
+			row = append(row, code)
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return nil
+}
+
+// changedSince returns the names whose index entry was created, stored,
+// renamed or used at or after since. Entries with no recorded times are
+// kept, since they may have changed.
+func changedSince(names []string, idx indexFile, since int64) []string {
+	var out []string
+	for _, name := range names {
+		e := idx.Entries[name]
+		if e.Created == 0 && e.Modified == 0 && e.LastUsed == 0 ||
+			max(e.Created, e.Modified, e.LastUsed) >= since {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+func compareInt64(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// listItemsFromKeyring lists names by enumerating the keyring instead of
+// reading the index. Names missing from the index are added back to it on a
+// best-effort basis. Backends that cannot enumerate fall back to listItems.
+func listItemsFromKeyring() ([]string, error) {
+	names, err := keyringNames()
+	if errors.Is(err, errListUnsupported) {
+		fmt.Fprintln(os.Stderr, "Warning: this keyring backend cannot be enumerated; falling back to the index.")
+		return listItems()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_ = updateIndex(func(idx *indexFile) error {
+		known := map[string]bool{}
+		for _, n := range idx.Names {
+			known[n] = true
+		}
+		for _, n := range names {
+			if !known[n] {
+				idx.Names = append(idx.Names, n)
+			}
+		}
+		return nil
+	})
+	return names, nil
+}
+
+func nameExists(name string) (bool, error) {
+	name, err := resolveName(name)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = keyringGet(name)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, keyring.ErrNotFound) {
+		return false, nil
+	}
+	return false, err
+}
+
+// completeNames returns the registered names for shell completion. With
+// ignoreCase, names are filtered by toComplete case-insensitively so shells
+// still offer "GitHub" when "git" was typed. TOTP_FAST_COMPLETION=1 skips
+// checking the names against the keyring, for slow keyrings.
+func completeNames(toComplete string) []string {
+	if prepareCompletion() != nil {
+		return nil
+	}
+	// Unlike listItems, never drop names from the index here: a keyring
+	// that cannot be reached would otherwise wipe it on every Tab.
+	list := func() ([]string, error) {
+		kept, _, err := splitIndexNames()
+		sortIndexNames(kept)
+		return kept, err
+	}
+	if os.Getenv("TOTP_FAST_COMPLETION") == "1" {
+		list = listIndexNames
+	}
+	names, err := list()
+	if err != nil {
+		return nil
+	}
+	if !ignoreCase {
+		return names
+	}
+
+	var out []string
+	for _, name := range names {
+		if strings.HasPrefix(strings.ToLower(name), strings.ToLower(toComplete)) {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// isNamePattern reports whether arg uses path.Match glob syntax.
+func isNamePattern(arg string) bool {
+	return strings.ContainsAny(arg, "*?[")
+}
+
+// expandNames resolves each of args to registered names. Glob patterns are
+// matched against the index (case-insensitively with --ignore-case); other
+// arguments go through resolveName as-is. Patterns that match nothing are
+// returned in unmatched. Duplicates are removed, keeping the first occurrence.
+func expandNames(args []string) (names, unmatched []string, err error) {
+	var indexed []string
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	for _, arg := range args {
+		if !isNamePattern(arg) {
+			name, err := resolveName(arg)
+			if err != nil {
+				return nil, nil, err
+			}
+			add(name)
+			continue
+		}
+
+		if indexed == nil {
+			idx, err := readIndex()
+			if err != nil {
+				return nil, nil, err
+			}
+			indexed = idx.Names
+		}
+		pattern := arg
+		if ignoreCase {
+			pattern = strings.ToLower(pattern)
+		}
+		matched := false
+		for _, name := range indexed {
+			candidate := name
+			if ignoreCase {
+				candidate = strings.ToLower(candidate)
+			}
+			ok, err := path.Match(pattern, candidate)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid pattern %q: %w", arg, err)
+			}
+			if ok {
+				matched = true
+				add(name)
+			}
+		}
+		if !matched {
+			unmatched = append(unmatched, arg)
+		}
+	}
+	return names, unmatched, nil
+}
+
+func completeAlgorithms(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return algorithmNames, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeIndexValues returns the distinct, sorted values pick extracts from
+// the index entries, for flag completion.
+func completeIndexValues(pick func(indexEntry) []string) []string {
+	if prepareCompletion() != nil {
+		return nil
+	}
+	idx, err := readIndex()
+	if err != nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	var out []string
+	for _, entry := range idx.Entries {
+		for _, v := range pick(entry) {
+			if v != "" && !seen[v] {
+				seen[v] = true
+				out = append(out, v)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// promptNewName returns initial if no entry has that name yet, and otherwise
+// asks for other names until a free one is typed. It fails with
+// ErrNameExists once standard input runs out.
+func promptNewName(initial string) (string, error) {
+	name := initial
+	for {
+		exists, err := nameExists(name)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return name, nil
+		}
+
+		fmt.Fprintf(os.Stderr, "Name \"%v\" already exists. Type new name: ", name)
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(os.Stderr)
+			return "", fmt.Errorf("%w: \"%v\"", ErrNameExists, name)
+		}
+		// An empty answer asks again for the same name.
+		if typed := strings.TrimSpace(line); typed != "" {
+			name = typed
+		}
+	}
+}
+
+// stdin is shared by every prompt so that buffered input is not lost between
+// them when answers are piped in.
+var stdin = bufio.NewReader(os.Stdin)
+
+const (
+	// maxQRImageBytes caps how much scan downloads, so a wrong URL cannot
+	// fill memory.
+	maxQRImageBytes   = 10 << 20
+	qrDownloadTimeout = 15 * time.Second
+)
+
+// openScanImage opens the image scan decodes: an http(s) URL is downloaded,
+// "-" reads standard input and anything else is a local file path.
+func openScanImage(src string) (io.ReadCloser, error) {
+	lower := strings.ToLower(src)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		if src == "-" {
+			return io.NopCloser(stdin), nil
+		}
+		return os.Open(src)
+	}
+
+	client := &http.Client{Timeout: qrDownloadTimeout}
+	resp, err := client.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading %v: %v", src, resp.Status)
+	}
+	if resp.ContentLength > maxQRImageBytes {
+		return nil, fmt.Errorf("downloading %v: image is larger than %d MiB", src, maxQRImageBytes>>20)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxQRImageBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("downloading %v: %w", src, err)
+	}
+	if len(body) > maxQRImageBytes {
+		return nil, fmt.Errorf("downloading %v: image is larger than %d MiB", src, maxQRImageBytes>>20)
+	}
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
+
+// scanSource decodes the QR code in the image at src (see openScanImage) and
+// parses its otpauth URI, also returning the image file's contents.
+// allFrames tries every frame of animated GIFs.
+func scanSource(src string, hints map[gozxing.DecodeHintType]interface{}, allFrames bool) (account, []byte, error) {
+	file, err := openScanImage(src)
+	if err != nil {
+		return account{}, nil, err
+	}
+	data, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return account{}, nil, err
+	}
+	a, err := scanImageData(data, hints, allFrames)
+	return a, data, err
+}
+
+// scanImageData decodes the QR code in an image's data, with every frame
+// tried if allFrames is set.
+func scanImageData(data []byte, hints map[gozxing.DecodeHintType]interface{}, allFrames bool) (account, error) {
+	var text string
+	var err error
+	if allFrames {
+		text, err = decodeQRFrames(bytes.NewReader(data), hints)
+	} else {
+		var img image.Image
+		if img, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+			return account{}, err
+		}
+		text, err = decodeQRImage(img, hints)
+	}
+	if err != nil {
+		return account{}, err
+	}
+	return parseScannedText(text)
+}
+
+// decodePastedImage decodes an image pasted as text, for scan --paste: a
+// data URI ("data:image/png;base64,...") as browser devtools copy it, or the
+// bare base64. Line breaks and spaces from wrapped pastes are ignored.
+func decodePastedImage(text string) ([]byte, error) {
+	text = strings.TrimSpace(text)
+	if rest, ok := strings.CutPrefix(text, "data:"); ok {
+		meta, payload, ok := strings.Cut(rest, ",")
+		if !ok || !strings.HasSuffix(meta, ";base64") {
+			return nil, errors.New("Pasted data URI is not base64-encoded (expected data:image/...;base64,...)")
+		}
+		text = payload
+	}
+	text = strings.Join(strings.Fields(text), "")
+	if text == "" {
+		return nil, errors.New("Nothing was pasted on standard input")
+	}
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		if data, err := enc.DecodeString(text); err == nil {
+			return data, nil
+		}
+	}
+	return nil, errors.New("Pasted text is neither a base64 data URI nor base64 image data")
+}
+
+// saveScanImage archives the image an entry was scanned from as
+// dir/<name>/<file name of src>, readable only by the user since it holds the
+// secret. Images read from standard input have no file name and are skipped
+// with a note.
+func saveScanImage(dir, name, src string, data []byte) error {
+	if src == "-" {
+		fmt.Fprintln(os.Stderr, "Note: not saving the image read from standard input; save it to a file and scan that instead.")
+		return nil
+	}
+	base := path.Base(src)
+	if u, err := url.Parse(src); err == nil && u.Scheme != "" && u.Host != "" {
+		base = path.Base(u.Path)
+	}
+	if base = filepath.Base(base); !filepath.IsLocal(base) {
+		base = "qr"
+	}
+
+	// Names may be anything, such as "..", but must stay a single directory
+	// inside dir.
+	sub := strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+	if sub == "." || !filepath.IsLocal(sub) {
+		sub = "_" + sub
+	}
+	target := filepath.Join(dir, sub)
+	if err := os.MkdirAll(target, 0o700); err != nil {
+		return err
+	}
+	file := filepath.Join(target, base)
+	if err := os.WriteFile(file, data, 0o600); err != nil {
+		return err
+	}
+	infof("Saved the image as %v.\n", file)
+	return nil
+}
+
+// newScanOrigin returns the origin to record for an image scanned from src,
+// or nil for standard input, which has none worth keeping.
+func newScanOrigin(src string, data []byte) *scanOrigin {
+	if src == "-" {
+		return nil
+	}
+	if u, err := url.Parse(src); err != nil || u.Scheme == "" || u.Host == "" {
+		if abs, err := filepath.Abs(src); err == nil {
+			src = abs
+		}
+	}
+	sum := sha256.Sum256(data)
+	return &scanOrigin{Path: src, SHA256: hex.EncodeToString(sum[:])}
+}
+
+// scanTargetName decides the name a scanned QR code is stored under. A name
+// that is taken is replaced with overwrite (reported in replaced), fails with
+// ErrNameExists when noPrompt is set, and otherwise prompts for a new one.
+func scanTargetName(name string, overwrite, noPrompt bool) (string, bool, error) {
+	switch {
+	case overwrite:
+		name, err := resolveName(name)
+		if err != nil {
+			return "", false, err
+		}
+		exists, err := nameExists(name)
+		return name, exists, err
+	case noPrompt:
+		exists, err := nameExists(name)
+		if err != nil {
+			return "", false, err
+		}
+		if exists {
+			return "", false, fmt.Errorf("%w: \"%v\" (pass --overwrite to replace it, or choose another name)", ErrNameExists, name)
+		}
+		return name, false, nil
+	default:
+		name, err := promptNewName(name)
+		return name, false, err
+	}
+}
+
+// scanMany scans several images for `scan <prefix> <image>...`, naming each
+// entry after prefix and the QR code's account label or issuer, or its
+// position when it has neither. Names repeated within the batch get a
+// numeric suffix. Failures are reported and skipped.
+func scanMany(prefix string, paths []string, hints map[gozxing.DecodeHintType]interface{}, allFrames, overwrite, noPrompt, recordSource bool, saveDir string) error {
+	registered := 0
+	used := map[string]int{}
+	for i, src := range paths {
+		a, data, err := scanSource(src, hints, allFrames)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", src, err)
+			continue
+		}
+		if recordSource {
+			a.Source = newScanOrigin(src, data)
+		}
+
+		suffix := strings.TrimSpace(a.Account)
+		if suffix == "" {
+			suffix = strings.TrimSpace(a.Issuer)
+		}
+		if suffix == "" {
+			suffix = strconv.Itoa(i + 1)
+		}
+		name := prefix + "-" + suffix
+		if used[name]++; used[name] > 1 {
+			name = fmt.Sprintf("%v-%d", name, used[name])
+		}
+		name, replaced, err := scanTargetName(name, overwrite, noPrompt)
+		if err == nil {
+			err = addItem(name, a)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v: %v\n", src, err)
+			continue
+		}
+
+		registered++
+		if replaced {
+			infof("%v: registered as \"%v\", replacing the previous entry.\n", src, name)
+		} else {
+			infof("%v: registered as \"%v\".\n", src, name)
+		}
+		if saveDir != "" {
+			if err := saveScanImage(saveDir, name, src, data); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v: could not save the image: %v\n", src, err)
+			}
+		}
+	}
+
+	infof("Registered %d of %d images.\n", registered, len(paths))
+	if registered < len(paths) {
+		return fmt.Errorf("Failed to register %d of %d images", len(paths)-registered, len(paths))
+	}
+	return nil
+}
+
+// envOr returns the environment variable name, or def when it is unset or
+// empty. It provides flag defaults that users can change without flags.
+func envOr(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+// envIntOr is envOr for integers; values that do not parse are ignored.
+func envIntOr(name string, def int) int {
+	if v, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return v
+	}
+	return def
+}
+
+// readLine prints prompt on stderr and reads a whole line from stdin, without
+// the line ending. Unlike fmt.Scanln it does not stop at spaces, so secrets
+// pasted in groups ("JBSW Y3DP ...") arrive intact. Prompts never go to
+// stdout, which carries only what a command outputs, e.g. the code of
+// `$(totp add ...)`.
+func readLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// confirm asks a yes/no question on stderr and reads the answer from stdin;
+// anything but "y" or "yes" is a no.
+func confirm(question string) (bool, error) {
+	fmt.Fprintf(os.Stderr, "%v [y/N]: ", question)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return false, err
+	}
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes", nil
+}
+
+func main() {
+	var useBarcodeHintWhenScan bool
+	var appendScan bool
+	var allFramesScan bool
+	var overwriteScan bool
+	var saveImageScan string
+	var recordSourceScan bool
+	var hintsScan []string
+	var screenScan, screenRegionScan bool
+	var pasteScan bool
+
+	var cmdScan = &cobra.Command{
+		Use:   "scan <name> <image>...",
+		Short: "Scan a QR code image",
+		Long: `Scan a QR code image and store it to the system keyring.
+
+The image may be a local file, an http(s) URL to download (up to 10 MiB) or
+"-" to read it from standard input.
+
+Given several images, <name> is a prefix: each entry is named
+<name>-<account label>, falling back to the issuer and then to the image's
+position (<name>-1, <name>-2, ...). Images that fail are reported and skipped,
+followed by a summary.
+
+--screen takes a screenshot of every display instead and scans the first
+QR code found, so no image file is needed; --screen-region lets you select
+the area to capture. They use screencapture on macOS, PowerShell on Windows,
+and grim (with slurp), gnome-screenshot, spectacle, scrot or ImageMagick's
+import on Linux, whichever is installed.
+
+--paste reads the image as text from standard input instead: a data URI
+("data:image/png;base64,...") as copied from browser devtools, or bare
+base64.
+
+--save-image <dir> keeps a copy of each image that was registered in
+<dir>/<name>/. Images read from standard input are not saved. The copies
+contain the secrets, like the originals.
+
+If the name is taken, a new one is asked for. When standard input is not a
+terminal (or holds the image) scan fails instead, so scripts never block;
+--overwrite replaces the existing entry in either case.
+
+Images that fail to decode can sometimes be coaxed with decoder hints, given
+as repeatable --hint key[=value] flags:
+
+  pure_barcode[=bool]   the image contains nothing but the code (same as -b)
+  try_harder[=bool]     spend more time looking for the code
+  character_set=NAME    character set of the payload, e.g. UTF-8 or ISO-8859-1
+
+Some minimal providers' QR codes hold just the Base32 secret rather than an
+otpauth URI. --allow-raw-secret accepts those, with the default parameters
+(SHA1, 6 digits, 30 seconds) and a warning, since the code cannot say
+otherwise.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if screenScan || screenRegionScan || pasteScan {
+				return cobra.ExactArgs(1)(cmd, args)
+			}
+			return cobra.MinimumNArgs(2)(cmd, args)
+		},
+
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			paths := args[1:]
+
+			hintSpecs := hintsScan
+			if useBarcodeHintWhenScan {
+				hintSpecs = append([]string{"pure_barcode"}, hintSpecs...)
+			}
+			hints, err := parseDecodeHints(hintSpecs)
+			if err != nil {
+				return err
+			}
+			if pasteScan {
+				paths = []string{"-"}
+			}
+			// Nobody is there to answer a prompt.
+			noPrompt := slices.Contains(paths, "-") || !isTerminal(os.Stdin)
+
+			if len(paths) > 1 {
+				if appendScan {
+					return errors.New("--append takes a single image")
+				}
+				return scanMany(name, paths, hints, allFramesScan, overwriteScan, noPrompt, recordSourceScan, saveImageScan)
+			}
+
+			var a account
+			var data []byte
+			if screenScan || screenRegionScan {
+				var src string
+				if a, src, data, err = scanScreen(screenRegionScan, hints); err != nil {
+					return err
+				}
+				paths = []string{src}
+			} else if pasteScan {
+				text, err := io.ReadAll(io.LimitReader(stdin, 2*maxQRImageBytes))
+				if err != nil {
+					return err
+				}
+				if data, err = decodePastedImage(string(text)); err != nil {
+					return err
+				}
+				if a, err = scanImageData(data, hints, allFramesScan); err != nil {
+					return err
+				}
+			} else if a, data, err = scanSource(paths[0], hints, allFramesScan); err != nil {
+				return err
+			} else if recordSourceScan {
+				a.Source = newScanOrigin(paths[0], data)
+			}
+
+			if appendScan {
+				if name, err = resolveName(name); err != nil {
+					return err
+				}
+				if err := appendBackup(name, a.Secret); err != nil {
+					return err
+				}
+				infof("Given QR code successfully added to \"%v\" as a backup secret.\n", name)
+				return nil
+			}
+
+			name, replaced, err := scanTargetName(name, overwriteScan, noPrompt)
+			if err != nil {
+				return err
+			}
+			err = addItem(name, a)
+			if err != nil {
+				return err
+			}
+			if replaced {
+				infof("Given QR code successfully registered as \"%v\", replacing the previous entry.\n", name)
+			} else {
+				infof("Given QR code successfully registered as \"%v\".\n", name)
+			}
+			if saveImageScan != "" {
+				return saveScanImage(saveImageScan, name, paths[0], data)
+			}
+			return nil
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) >= 1 {
+				return nil, cobra.ShellCompDirectiveDefault
+			}
+
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	cmdScan.Flags().BoolVarP(
+		&useBarcodeHintWhenScan,
+		"barcode",
+		"b",
+		false,
+		"use PURE_BARCODE hint for decoding. this flag maybe solves FormatException",
+	)
+	cmdScan.Flags().StringArrayVar(&hintsScan, "hint", nil, "decoder hint as key[=value]: pure_barcode, try_harder or character_set (repeatable)")
+	cmdScan.RegisterFlagCompletionFunc("hint", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{"pure_barcode", "try_harder", "character_set="}, cobra.ShellCompDirectiveNoFileComp | cobra.ShellCompDirectiveNoSpace
+	})
+	cmdScan.Flags().BoolVar(&allFramesScan, "all-frames", false, "try every frame of an animated GIF, not just the first")
+	cmdScan.Flags().BoolVar(&appendScan, "append", false, "add the QR code's secret to an existing entry as a backup secret")
+	cmdScan.Flags().BoolVar(&overwriteScan, "overwrite", false, "replace an existing entry with the same name instead of asking for another name")
+	cmdScan.MarkFlagsMutuallyExclusive("append", "overwrite")
+	cmdScan.Flags().StringVar(&saveImageScan, "save-image", "", "copy each decoded image to <dir>/<name>/ as an offline record")
+	cmdScan.MarkFlagDirname("save-image")
+	cmdScan.Flags().BoolVar(&recordSourceScan, "record-source", false, "remember the image file (or URL) and its SHA-256 in the entry; shown by list --long")
+	cmdScan.MarkFlagsMutuallyExclusive("record-source", "append")
+	cmdScan.Flags().BoolVar(&allowRawSecrets, "allow-raw-secret", false, "accept QR codes holding a bare Base32 secret, with the default parameters")
+	cmdScan.Flags().BoolVar(&screenScan, "screen", false, "scan a screenshot of every display instead of an image file")
+	cmdScan.Flags().BoolVar(&screenRegionScan, "screen-region", false, "scan a screenshot of a region you select instead of an image file")
+	cmdScan.Flags().BoolVar(&pasteScan, "paste", false, "read the image from standard input as a base64 data URI (or bare base64) instead of an image file")
+	cmdScan.MarkFlagsMutuallyExclusive("screen", "screen-region", "paste")
+	cmdScan.MarkFlagsMutuallyExclusive("screen", "screen-region", "all-frames")
+	// Screenshots are deleted once scanned, so there is nothing to point at.
+	cmdScan.MarkFlagsMutuallyExclusive("record-source", "screen")
+	cmdScan.MarkFlagsMutuallyExclusive("record-source", "screen-region")
+	cmdScan.Flags().BoolVar(&noQRFallback, "no-fallback", false, "fail fast instead of retrying unreadable images with slower decoding (try_harder, pure_barcode, inverted)")
+
+	var jobsImportDir int
+	var hintsImportDir []string
+	var atomicImportDir bool
+	var cmdImportDir = &cobra.Command{
+		Use:   "import-dir <directory>",
+		Short: "Scan every QR code image in a directory",
+		Long: `Scan every QR code image (PNG, JPEG, GIF or BMP) directly inside a directory
+and store each one under its file name without the extension, e.g.
+github.png becomes "github".
+
+Images are decoded in parallel (--jobs, default the number of CPUs). Names
+that are already taken are asked about one by one once decoding is done; an
+empty answer skips that image. A summary in file name order follows.
+
+With --atomic the import is all or nothing: if any image cannot be decoded,
+nothing is stored, and if storing an entry fails part way through (e.g. the
+keyring is full), the entries already added are deleted and the index is
+restored.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			hints, err := parseDecodeHints(hintsImportDir)
+			if err != nil {
+				return err
+			}
+
+			results, err := scanDir(args[0], jobsImportDir, hints)
+			if err != nil {
+				return err
+			}
+			if len(results) == 0 {
+				return fmt.Errorf("No images found in %v", args[0])
+			}
+
+			items := make([]pendingImport, len(results))
+			for i, r := range results {
+				items[i] = pendingImport{label: r.file, name: r.name, a: r.a, err: r.err}
+			}
+			return storeImports(items, atomicImportDir, "images")
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return nil, cobra.ShellCompDirectiveFilterDirs
+		},
+	}
+
+	cmdImportDir.Flags().IntVarP(&jobsImportDir, "jobs", "j", runtime.NumCPU(), "number of images to decode at once")
+	cmdImportDir.Flags().StringArrayVar(&hintsImportDir, "hint", nil, "decoder hint as key[=value], as for scan (repeatable)")
+	cmdImportDir.Flags().BoolVar(&allowRawSecrets, "allow-raw-secret", false, "accept QR codes holding a bare Base32 secret, with the default parameters")
+	cmdImportDir.Flags().BoolVar(&noQRFallback, "no-fallback", false, "fail fast instead of retrying unreadable images with slower decoding, as for scan")
+	cmdImportDir.Flags().BoolVar(&atomicImportDir, "atomic", false, "import all images or none, rolling back on the first failure")
+
+	var formatImport string
+	var atomicImport bool
+	var cmdImport = &cobra.Command{
+		Use:   "import --format <1password|bitwarden> <file>",
+		Short: "Import one-time passwords from a password manager export",
+		Long: `Import the one-time passwords in a password manager export.
+
+--format 1password reads a 1Password CSV export (the OTPAuth or "one-time
+password" column). --format bitwarden reads an unencrypted Bitwarden JSON or
+CSV export (login.totp or login_totp). The field may hold an otpauth URI or a
+bare Base32 secret; entries without one are ignored.
+
+Each entry is stored under its title in the export, falling back to the
+issuer or account of its otpauth URI. Names that are already taken are asked
+about one by one; an empty answer skips that entry. A summary follows.
+
+With --atomic the import is all or nothing: if any entry cannot be read,
+nothing is stored, and if storing an entry fails part way through (e.g. the
+keyring is full), the entries already added are deleted and the index is
+restored.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			records, err := parseImport(data, formatImport)
+			if err != nil {
+				return err
+			}
+			if len(records) == 0 {
+				return fmt.Errorf("No one-time passwords found in %v", args[0])
+			}
+
+			items := make([]pendingImport, len(records))
+			for i, r := range records {
+				items[i] = pendingImport{label: r.where, name: r.importName(), a: r.a, err: r.err}
+				if r.err == nil && items[i].name == "" {
+					items[i].err = errors.New("no title, issuer or account to name it by")
+				}
+			}
+			return storeImports(items, atomicImport, "entries")
+		},
+	}
+
+	cmdImport.Flags().StringVar(&formatImport, "format", "", "export format: 1password or bitwarden")
+	cmdImport.Flags().BoolVar(&atomicImport, "atomic", false, "import all entries or none, rolling back on the first failure")
+	cmdImport.MarkFlagRequired("format")
+	cmdImport.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{importFormat1Password, importFormatBitwarden}, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	var copyAdd bool
+	var issuerAdd, accountAdd, algorithmAdd string
+	var digitsAdd, periodAdd int
+	var protectAdd bool
+	var tagsAdd []string
+	var maxAgeAdd string
+	var interactiveAdd bool
+	var fromFileAdd string
+	var appendAdd bool
+	var baseTimeAdd string
+	var counterAdd uint64
+	var timeSourceAdd string
+	var cmdAdd = &cobra.Command{
+		Use:   "add <name>",
+		Short: "Manually add a secret to the system keyring",
+		Long: `Manually add a secret to the system keyring.
+
+With --interactive, the name, secret, digits, period, algorithm and issuer
+are asked for one by one; press Enter to accept the default in brackets.
+
+--time-source computes the entry's codes against a server's clock instead of
+the local one, for services whose servers are known to drift: an NTP server
+(e.g. time.example.com) or an http(s) URL whose Date header is used. If it
+cannot be reached, the local clock is used with a warning.
+
+--counter N adds a counter-based (HOTP) entry starting at counter N instead
+of a time-based one. No code is previewed, since that would use it up.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if interactiveAdd {
+				return cobra.MaximumNArgs(1)(cmd, args)
+			}
+			return cobra.ExactArgs(1)(cmd, args)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkParams(algorithmAdd, digitsAdd, periodAdd); err != nil {
+				return err
+			}
+			var maxAge time.Duration
+			if maxAgeAdd != "" {
+				var err error
+				if maxAge, err = parseMaxAge(maxAgeAdd); err != nil {
+					return err
+				}
+			}
+
+			a := newAccount("")
+			a.Algorithm = strings.ToUpper(algorithmAdd)
+			a.Digits = digitsAdd
+			a.Period = periodAdd
+			if baseTimeAdd != "" {
+				var err error
+				if a.T0, err = parseBaseTime(baseTimeAdd); err != nil {
+					return err
+				}
+			}
+			if cmd.Flags().Changed("counter") {
+				a.Type = accountTypeHOTP
+				a.Counter = counterAdd
+			}
+			if timeSourceAdd != "" {
+				if err := checkTimeSource(timeSourceAdd); err != nil {
+					return err
+				}
+				a.TimeSource = timeSourceAdd
+			}
+			a.Issuer = issuerAdd
+			a.Account = accountAdd
+			a.Tags = tagsAdd
+
+			var name string
+			if interactiveAdd {
+				if len(args) == 1 {
+					name = args[0]
+				}
+				var err error
+				if name, a, err = addWizard(name, a); err != nil {
+					return err
+				}
+			} else {
+				var err error
+				if appendAdd {
+					name, err = resolveName(args[0])
+				} else {
+					name, err = promptNewName(args[0])
+				}
+				if err != nil {
+					return err
+				}
+
+				var secret string
+				if fromFileAdd != "" {
+					b, err := os.ReadFile(fromFileAdd)
+					if err != nil {
+						return err
+					}
+					secret = strings.TrimSpace(string(b))
+					wipe(b)
+				} else {
+					// Read secret from stdin
+					if secret, err = readLine("Type secret: "); err != nil {
+						return err
+					}
+				}
+				if a.Secret, err = normalizeAndValidateSecret(secret); err != nil {
+					return err
+				}
+			}
+
+			if appendAdd {
+				if err := appendBackup(name, a.Secret); err != nil {
+					return err
+				}
+				infof("Given secret successfully added to \"%v\" as a backup secret.\n", name)
+				return nil
+			}
+
+			// Preview the code with the very account that is stored below, so
+			// it matches what the service expects.
+			var code string
+			var err error
+			if a.Type != accountTypeHOTP {
+				if code, err = a.code(accountTime(a, time.Now())); err != nil {
+					return err
+				}
+			}
+			if params := a.paramsSummary(); params != "" {
+				infof("Parameters: %v\n", params)
+			}
+			if a.Type == accountTypeHOTP {
+				infof("Counter: %d\n", a.Counter)
+			} else if copyAdd {
+				infof("Current code: ")
+				if err := outputCode(code, true, false); err != nil {
+					return err
+				}
+			} else {
+				printData("Current code", code)
+			}
+
+			if protectAdd {
+				passphrase, err := readNewPassphrase()
+				if err != nil {
+					return err
+				}
+				a.Protected, err = sealSecret(a.Secret, passphrase)
+				wipe(passphrase)
+				if err != nil {
+					return err
+				}
+			}
+
+			err = addItem(name, a)
+			if err != nil {
+				return err
+			}
+			if maxAge > 0 {
+				if err := setRotateAfter(name, maxAge); err != nil {
+					return err
+				}
+			}
+			infof("Given secret successfully registered as \"%v\".\n", name)
+			return nil
+		},
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+
+	cmdAdd.Flags().BoolVarP(&copyAdd, "copy", "c", false, "copy the current code to the clipboard")
+	cmdAdd.Flags().StringVar(&issuerAdd, "issuer", "", "issuer (service provider) to record with the secret")
+	cmdAdd.Flags().StringVar(&accountAdd, "account", "", "account (user) label to record with the secret")
+	cmdAdd.Flags().StringVar(
+		&algorithmAdd,
+		"algorithm",
+		envOr("TOTP_DEFAULT_ALGORITHM", "sha1"),
+		"HMAC algorithm: sha1, sha256 or sha512 (also set by TOTP_DEFAULT_ALGORITHM)",
+	)
+	cmdAdd.Flags().IntVar(
+		&digitsAdd,
+		"digits",
+		envIntOr("TOTP_DEFAULT_DIGITS", defaultDigits),
+		"number of digits in a code (also set by TOTP_DEFAULT_DIGITS)",
+	)
+	cmdAdd.Flags().IntVar(
+		&periodAdd,
+		"period",
+		envIntOr("TOTP_DEFAULT_PERIOD", defaultPeriod),
+		"seconds each code is valid for (also set by TOTP_DEFAULT_PERIOD)",
+	)
+	cmdAdd.Flags().StringVar(&baseTimeAdd, "base-time", "", "time steps are counted from (T0), as Unix seconds or RFC 3339; almost always the default, 0")
+	cmdAdd.Flags().BoolVar(&protectAdd, "protect", false, "encrypt the secret with a passphrase that get will ask for")
+	cmdAdd.Flags().StringArrayVar(&tagsAdd, "tag", nil, "tag to attach to the entry (repeatable)")
+	cmdAdd.Flags().BoolVarP(&interactiveAdd, "interactive", "i", false, "ask for each parameter, with defaults, instead of taking them from flags")
+	cmdAdd.Flags().StringVar(&fromFileAdd, "from-file", "", "read the secret from this file instead of prompting")
+	cmdAdd.MarkFlagsMutuallyExclusive("interactive", "from-file")
+	cmdAdd.Flags().BoolVar(&appendAdd, "append", false, "add the secret to an existing entry as a backup secret")
+	cmdAdd.MarkFlagsMutuallyExclusive("append", "interactive")
+	cmdAdd.MarkFlagsMutuallyExclusive("append", "protect")
+	cmdAdd.Flags().Uint64Var(&counterAdd, "counter", 0, "add a counter-based (HOTP) entry starting at this counter")
+	cmdAdd.MarkFlagsMutuallyExclusive("counter", "base-time")
+	cmdAdd.Flags().StringVar(&timeSourceAdd, "time-source", "", "compute codes against this NTP server or http(s) URL's clock instead of the local one")
+	cmdAdd.MarkFlagsMutuallyExclusive("counter", "time-source")
+	cmdAdd.MarkFlagsMutuallyExclusive("counter", "copy")
+	cmdAdd.MarkFlagsMutuallyExclusive("counter", "interactive")
+	cmdAdd.MarkFlagsMutuallyExclusive("counter", "append")
+	cmdAdd.Flags().StringVar(&maxAgeAdd, "max-age", "", "remind to rotate the secret once it is older than this (e.g. 90d or 720h)")
+	cmdAdd.RegisterFlagCompletionFunc("algorithm", completeAlgorithms)
+	cmdAdd.RegisterFlagCompletionFunc("issuer", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeIndexValues(func(e indexEntry) []string { return []string{e.Issuer} }), cobra.ShellCompDirectiveNoFileComp
+	})
+	cmdAdd.RegisterFlagCompletionFunc("tag", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeIndexValues(func(e indexEntry) []string { return e.Tags }), cobra.ShellCompDirectiveNoFileComp
+	})
+
+	var longList, noIndexList, noVerifyList, codesList, jsonLinesList, countList, maskList, tsvList, noHeaderList bool
+	var sortList, changedSinceList, groupByList string
+	var cmdList = &cobra.Command{
+		Use:   "list",
+		Short: "List all registered TOTP codes",
+		Long: `List all registered entries.
+
+--changed-since T lists only the entries added, changed, renamed or used at
+or after T (Unix seconds or RFC 3339), for tools that mirror the entries
+incrementally; --json-lines includes each entry's created, modified and
+last_used times. Entries without recorded times are always listed.
+
+--tsv prints tab-separated name, code, expires_in and issuer columns for
+spreadsheets and awk, after a header line unless --no-header is given. The
+code and expires_in columns are empty for HOTP and protected entries.
+
+--group-by issuer (or tag) prints a section per issuer (or tag), in
+alphabetical order, with the entries beneath it; entries without one come
+last, and an entry with several tags appears under each. Add --codes to show
+each entry's code too.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if maskList && !codesList && !tsvList {
+				return errors.New("--mask requires --codes or --tsv")
+			}
+			if noHeaderList && !tsvList {
+				return errors.New("--no-header requires --tsv")
+			}
+			var since int64
+			if changedSinceList != "" {
+				var ok bool
+				if since, ok = parseUnixTime(changedSinceList); !ok {
+					return fmt.Errorf("invalid --changed-since: %q (expected Unix seconds or e.g. 2024-01-01T00:00:00Z)", changedSinceList)
+				}
+			}
+			list := listItems
+			if noIndexList {
+				list = listItemsFromKeyring
+			} else if noVerifyList || (countList && !cmd.Flags().Changed("no-verify")) {
+				// Counting trusts the index unless asked not to.
+				list = listIndexNames
+			}
+			names, err := list()
+			if err != nil {
+				return err
+			}
+			idx, err := readIndex()
+			if err != nil {
+				return err
+			}
+			if changedSinceList != "" {
+				names = changedSince(names, idx, since)
+			}
+			if countList {
+				fmt.Println(len(names))
+				return nil
+			}
+			if len(names) == 0 {
+				if changedSinceList == "" && !quiet {
+					// stderr only, so scripts still see empty output.
+					fmt.Fprintln(os.Stderr, "No accounts yet. Add one with 'totp add <name>' or 'totp scan <name> <image>'.")
+				}
+				return nil
+			}
+
+			by := sortList
+			if indexOrder == indexOrderNone && !cmd.Flags().Changed("sort") {
+				by = "index"
+			}
+			if err := sortNames(names, by, idx); err != nil {
+				return err
+			}
+			defer warnRotation(names, idx, time.Now())
+
+			groups := []nameGroup{{names: names}}
+			if groupByList != "" {
+				if groups, err = groupNames(names, groupByList, idx); err != nil {
+					return err
+				}
+			}
+
+			if !longList && !codesList && !jsonLinesList && !tsvList && groupByList == "" {
+				for _, name := range names {
+					fmt.Println(name)
+				}
+				return nil
+			}
+
+			now := time.Now()
+			if jsonLinesList {
+				enc := json.NewEncoder(os.Stdout)
+				for _, name := range names {
+					a, err := getItem(name)
+					if err != nil {
+						return err
+					}
+
+					rec := listRecord{
+						Name:    name,
+						Type:    accountTypeTOTP,
+						Issuer:  a.Issuer,
+						Account: a.Account,
+						Tags:    a.Tags,
+						Locked:  a.Protected != nil,
+
+						Created:  idx.Entries[name].Created,
+						Modified: idx.Entries[name].Modified,
+						LastUsed: idx.Entries[name].LastUsed,
+
+						Source: a.Source,
+					}
+					if a.Type == accountTypeHOTP {
+						rec.Type = accountTypeHOTP
+						rec.Counter = &a.Counter
+					}
+					if codesList {
+						code, expiresIn, ok, err := listCode(a, now, maskList)
+						if err != nil {
+							return err
+						}
+						if ok {
+							rec.Code, rec.ExpiresIn = code, expiresIn
+						}
+					}
+					if err := enc.Encode(rec); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			if tsvList {
+				// Tabs and newlines in a field would shift the columns.
+				field := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace
+				if !noHeaderList {
+					fmt.Println("name\tcode\texpires_in\tissuer")
+				}
+				for _, name := range names {
+					a, err := getItem(name)
+					if err != nil {
+						return err
+					}
+					code, expiresIn, ok, err := listCode(a, now, maskList)
+					if err != nil {
+						return err
+					}
+					expires := ""
+					if ok {
+						expires = strconv.Itoa(expiresIn)
+					}
+					fmt.Printf("%v\t%v\t%v\t%v\n", field(name), code, expires, field(a.Issuer))
+				}
+				return nil
+			}
+
+			w := newTableWriter(os.Stdout, 2)
+			if longList {
+				header := "NAME\tISSUER\tACCOUNT\tTAGS\tCOUNTER\tSOURCE"
+				if codesList {
+					header += "\tCODE"
+				}
+				fmt.Fprintln(w, header)
+			}
+			indent := ""
+			for i, group := range groups {
+				if group.title != "" {
+					if i > 0 {
+						fmt.Fprintln(w)
+					}
+					fmt.Fprintf(w, "%v:\n", group.title)
+					indent = "  "
+				}
+				if err := writeListRows(w, group.names, indent, longList, codesList, maskList, now); err != nil {
+					return err
+				}
+			}
+			return w.Flush()
+		},
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+
+	cmdList.Flags().BoolVar(&noIndexList, "no-index", false, "enumerate entries from the keyring instead of ~/.totp.json")
+	cmdList.Flags().BoolVar(&noVerifyList, "no-verify", false, "trust ~/.totp.json instead of checking each name against the keyring")
+	cmdList.MarkFlagsMutuallyExclusive("no-index", "no-verify")
+	cmdList.Flags().BoolVarP(&longList, "long", "l", false, "also show the issuer, account, tags, HOTP counter and recorded scan source of each entry")
+	cmdList.Flags().BoolVar(&codesList, "codes", false, "also show the current code of each entry")
+	cmdList.Flags().BoolVar(&maskList, "mask", false, "with --codes or --tsv, print each code as asterisks")
+	cmdList.Flags().BoolVar(&jsonLinesList, "json-lines", false, "print one JSON object per entry and line (NDJSON)")
+	cmdList.MarkFlagsMutuallyExclusive("long", "json-lines")
+	cmdList.Flags().BoolVar(&countList, "count", false, "print only the number of entries, from the index unless --no-verify=false or --no-index is given")
+	cmdList.Flags().BoolVar(&tsvList, "tsv", false, "print tab-separated name, code, expires_in and issuer columns")
+	cmdList.Flags().BoolVar(&noHeaderList, "no-header", false, "with --tsv, leave out the header line")
+	cmdList.Flags().StringVar(&groupByList, "group-by", "", "print a section per issuer or tag, with the entries beneath it")
+	cmdList.RegisterFlagCompletionFunc("group-by", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return listGroupings, cobra.ShellCompDirectiveNoFileComp
+	})
+	for _, flag := range []string{"count", "json-lines", "tsv"} {
+		cmdList.MarkFlagsMutuallyExclusive("group-by", flag)
+	}
+	for _, flag := range []string{"long", "codes", "json-lines", "tsv"} {
+		cmdList.MarkFlagsMutuallyExclusive("count", flag)
+	}
+	for _, flag := range []string{"long", "codes", "json-lines"} {
+		cmdList.MarkFlagsMutuallyExclusive("tsv", flag)
+	}
+	cmdList.Flags().StringVar(&sortList, "sort", "name", "display order: name, issuer, recent (last used), created or index (as stored; the default with --index-order none)")
+	cmdList.Flags().StringVar(&changedSinceList, "changed-since", "", "list only entries added, changed or used since this time (Unix seconds or RFC 3339)")
+	cmdList.RegisterFlagCompletionFunc("sort", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return listSortOrders, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	var copyGet bool
+	var formatGet string
+	var statusbarGet bool
+	var verifyAgainstGet string
+	var verifyWindowGet int
+	var checkTimeGet bool
+	var watchGet bool
+	var clearOnExitGet bool
+	var alignGet string
+	var retryOnLockGet bool
+	var exitAtExpiryGet bool
+	var maskGet bool
+	var waitGet bool
+	var minRemainingGet int
+	var showNameGet bool
+	var jsonGet bool
+	var includeSecretGet, confirmIncludeSecretGet bool
+	var allAlgorithmsGet bool
+	var ntpServerGet string
+	var offsetStepGet int
+	var execGet string
+	var truncateToGet int
+	var truncateFromGet string
+	var execTimeoutGet time.Duration
+	var cmdGet = &cobra.Command{
+		Use:   "get [name]",
+		Short: "Get a TOTP code",
+		Long: `Get a TOTP code from the system keyring.
+
+Without a name argument, the name is taken from TOTP_NAME.
+
+--offset-step N prints the code N time steps away from now instead (negative
+for past steps), e.g. to test how many steps a server accepts. The step used
+and its validity window are shown on stderr.
+
+--watch --copy copies each new code to the clipboard as it comes up. Add
+--clipboard-clear-on-exit to clear the clipboard when watching ends, including
+on Ctrl-C, unless something else was copied since.
+
+--wait sleeps until the next time step begins and prints its code, so the
+code stays valid as long as possible. --min-remaining N waits only if fewer
+than N seconds of the current code are left.
+
+--mask prints every digit as "*", e.g. while sharing your screen; with
+--copy, the real code only goes to the clipboard.
+
+--json --include-secret adds the Base32 secret to the JSON object, for
+trusted automation that needs the secret itself. Anyone who sees the output
+can generate your codes forever, so it also requires
+--confirm-include-secret. Never log the output or pass it through shared
+pipelines.
+
+--all-algorithms is a debug aid for codes the service rejects: it prints the
+current code under each of sha1, sha256 and sha512, marking the stored one,
+so you can see at a glance whether the algorithm is to blame. Use
+"totp verify --algorithm auto" to check against a code you know is good.
+
+--retry-on-lock asks you to unlock a locked keyring (macOS keychain or
+Secret Service) and press Enter, up to three times, instead of failing.
+
+--watch --time-step-boundary-wait waits for the next whole second before the
+first line so the countdown ticks evenly; =step waits for the next code
+instead.
+
+--watch --exit-at-expiry exits (with status 0) the moment the first code
+shown expires, after printing it as usual, so a script or status bar that
+runs it knows exactly when to fetch the next one. Combined with
+--time-step-boundary-wait=step, that is the end of the next time step.
+
+--truncate-to N prints only N characters of the code, the rightmost unless
+--truncate-from left, for the rare provider that expects a shortened code.
+The stored number of digits is unchanged.
+
+--exec CMD runs CMD through the shell once the code has been printed, e.g.
+--exec 'ydotool type --file -' to type it into the focused window. The code
+is passed in TOTP_CODE and on standard input, never as an argument, along
+with TOTP_NAME and TOTP_EXPIRES_IN. The command's output goes to stderr. It is
+killed after --exec-timeout, and a non-zero exit status fails get.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			if clearOnExitGet && !(watchGet && copyGet) {
+				return errors.New("--clipboard-clear-on-exit requires --watch and --copy")
+			}
+			if retryOnLockGet {
+				lockRetries = 3
+			}
+			if includeSecretGet && !jsonGet {
+				return errors.New("--include-secret requires --json")
+			}
+			if includeSecretGet && !confirmIncludeSecretGet {
+				return errors.New("--include-secret prints the secret, which lets anyone generate your codes; pass --confirm-include-secret if you are sure")
+			}
+			if exitAtExpiryGet && !watchGet {
+				return errors.New("--exit-at-expiry requires --watch")
+			}
+			if alignGet != "" && !watchGet {
+				return errors.New("--time-step-boundary-wait requires --watch")
+			}
+			if cmd.Flags().Changed("truncate-from") && !cmd.Flags().Changed("truncate-to") {
+				return errors.New("--truncate-from requires --truncate-to")
+			}
+			if truncateFromGet != truncateRight && truncateFromGet != truncateLeft {
+				return fmt.Errorf("unknown --truncate-from %q (expected %v or %v)", truncateFromGet, truncateRight, truncateLeft)
+			}
+			if cmd.Flags().Changed("exec-timeout") && execGet == "" {
+				return errors.New("--exec-timeout requires --exec")
+			}
+			if alignGet != "" && alignGet != watchAlignSecond && alignGet != watchAlignStep {
+				return fmt.Errorf("unknown --time-step-boundary-wait %q (expected %v or %v)", alignGet, watchAlignSecond, watchAlignStep)
+			}
+
+			name := os.Getenv("TOTP_NAME")
+			if len(args) == 1 {
+				name = args[0]
+			}
+			if name == "" {
+				return errors.New("No name given (pass it as an argument or set TOTP_NAME)")
+			}
+
+			if checkTimeGet {
+				warnClockSkew(ntpServerGet)
+			}
+
+			if allAlgorithmsGet {
+				name, err := resolveName(name)
+				if err != nil {
+					return err
+				}
+				a, err := getUnlockedItem(name)
+				if err != nil {
+					return err
+				}
+				if a.Type == accountTypeHOTP {
+					return errors.New("--all-algorithms only works with TOTP entries")
+				}
+				now := accountTime(a, time.Now())
+				for _, alg := range algorithmNames {
+					try := a
+					try.Algorithm = alg
+					code, err := try.code(now)
+					if err != nil {
+						return err
+					}
+					mark := ""
+					if strings.EqualFold(alg, a.Algorithm) {
+						mark = "  (stored)"
+					}
+					fmt.Printf("%-7v %v%v\n", alg, code, mark)
+				}
+				return nil
+			}
+
+			if verifyAgainstGet != "" {
+				a, err := getUnlockedItem(name)
+				if err != nil {
+					return err
+				}
+				if a.Type == accountTypeHOTP {
+					return errors.New("--verify-against only works with TOTP entries")
+				}
+
+				return verifyCode(a, strings.TrimSpace(verifyAgainstGet), accountTime(a, time.Now()), verifyWindowGet)
+			}
+
+			if watchGet {
+				name, err := resolveName(name)
+				if err != nil {
+					return err
+				}
+				a, err := getUnlockedItem(name)
+				if err != nil {
+					return err
+				}
+				if a.Type == accountTypeHOTP {
+					return errors.New("--watch only works with TOTP entries")
+				}
+				_ = recordUse(name)
+				return watchCode(name, a, watchOptions{
+					copy:         copyGet,
+					clearOnExit:  clearOnExitGet,
+					align:        alignGet,
+					exitAtExpiry: exitAtExpiryGet,
+					mask:         maskGet,
+				})
+			}
+
+			if statusbarGet {
+				// Status bars poll on a timer: never retry, never fail loudly.
+				keyringRetries = 0
+				info, err := statusbarCode(name, time.Now())
+				if err == nil && cmd.Flags().Changed("truncate-to") {
+					info.Code, err = truncateCode(info.Code, truncateToGet, truncateFromGet)
+				}
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					fmt.Println()
+					return nil
+				}
+				code := info.Code
+				if maskGet {
+					code = maskCode(code)
+				}
+				fmt.Printf("%v (%vs)\n", code, info.ExpiresIn)
+				return nil
+			}
+
+			tmpl, err := parseCodeTemplate(formatGet)
+			if err != nil {
+				return err
+			}
+
+			var info codeInfo
+			if cmd.Flags().Changed("offset-step") {
+				info, err = offsetStepCode(name, offsetStepGet, time.Now())
+			} else if cmd.Flags().Changed("min-remaining") {
+				info, err = freshCode(name, minRemainingGet)
+			} else if waitGet {
+				info, err = freshCode(name, math.MaxInt)
+			} else {
+				info, err = currentCode(name, time.Now())
+			}
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("truncate-to") {
+				if info.Code, err = truncateCode(info.Code, truncateToGet, truncateFromGet); err != nil {
+					return err
+				}
+				for i, code := range info.BackupCodes {
+					if info.BackupCodes[i], err = truncateCode(code, truncateToGet, truncateFromGet); err != nil {
+						return err
+					}
+				}
+				if info.PendingCode != "" {
+					if info.PendingCode, err = truncateCode(info.PendingCode, truncateToGet, truncateFromGet); err != nil {
+						return err
+					}
+				}
+			}
+			_ = recordUse(info.Name)
+			if idx, err := readIndex(); err == nil {
+				defer warnRotation([]string{info.Name}, idx, time.Now())
+			}
+			if execGet != "" {
+				defer func() {
+					if err == nil {
+						err = runExecHook(execGet, info, execTimeoutGet)
+					}
+				}()
+			}
+			if includeSecretGet {
+				fmt.Fprintln(os.Stderr, "Warning: the output contains the secret, which lets anyone generate your codes. Do not share or log it.")
+				return json.NewEncoder(os.Stdout).Encode(struct {
+					codeInfo
+					Secret string `json:"secret"`
+				}{info, info.secret})
+			}
+			if jsonGet {
+				return json.NewEncoder(os.Stdout).Encode(info)
+			}
+			if !cmd.Flags().Changed("format") {
+				prefix := ""
+				if showNameGet {
+					prefix = info.Name + ": "
+				}
+				if info.pendingOverdue && !quiet {
+					fmt.Fprintf(os.Stderr, "Reminder: the rotation of \"%v\" is past its grace period; run \"totp confirm-rotation %v\" once the new secret works.\n", info.Name, info.Name)
+				}
+				if (len(info.BackupCodes) > 0 || info.PendingCode != "") && !copyGet {
+					show := func(code string) string { return code }
+					if maskGet {
+						show = maskCode
+					}
+					fmt.Printf("%vprimary: %v\n", prefix, show(info.Code))
+					for i, code := range info.BackupCodes {
+						label := "backup"
+						if len(info.BackupCodes) > 1 {
+							label = fmt.Sprintf("backup %d", i+1)
+						}
+						fmt.Printf("%v%v: %v\n", prefix, label, show(code))
+					}
+					if info.PendingCode != "" {
+						fmt.Printf("%vpending: %v\n", prefix, show(info.PendingCode))
+					}
+					return nil
+				}
+				if info.PendingCode != "" && !quiet {
+					fmt.Fprintln(os.Stderr, "Note: a rotation is pending; copying the code of the current secret. Run without --copy to see both.")
+				}
+				fmt.Print(prefix)
+				return outputCode(info.Code, copyGet, maskGet)
+			}
+
+			if err := tmpl.Execute(os.Stdout, info); err != nil {
+				return err
+			}
+			fmt.Println()
+			return nil
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return completeNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	cmdGet.Flags().BoolVarP(&copyGet, "copy", "c", false, "copy the current code to the clipboard")
+	cmdGet.Flags().StringVar(
+		&formatGet,
+		"format",
+		"{{.Code}}",
+		"Go template for the output; fields: .Name .Code .ExpiresIn .Issuer .Account .Period .ValidFrom .ValidUntil .BackupCodes .PendingCode",
+	)
+	cmdGet.Flags().BoolVar(
+		&statusbarGet,
+		"statusbar",
+		false,
+		`print "<code> (<seconds>s)" for status bars (unprotected TOTP entries only, local clock); on error print an empty line and exit 0`,
+	)
+	cmdGet.Flags().StringVar(&verifyAgainstGet, "verify-against", "", "report which nearby time step (if any) produces the given code")
+	cmdGet.Flags().IntVar(&verifyWindowGet, "window", 3, "number of steps either side of now to search with --verify-against")
+	cmdGet.Flags().BoolVar(&checkTimeGet, "check-time", false, "warn if the system clock disagrees with an NTP server (network access, up to 2s)")
+	cmdGet.Flags().StringVar(&ntpServerGet, "ntp-server", defaultNTPServer, "NTP server to query with --check-time")
+	cmdGet.Flags().BoolVarP(&watchGet, "watch", "w", false, "keep showing the current code with a countdown until interrupted")
+	cmdGet.Flags().BoolVar(&showNameGet, "show-name", false, `print "<name>: <code>" instead of just the code`)
+	cmdGet.Flags().BoolVar(&jsonGet, "json", false, "print the code and its validity window as a JSON object")
+	cmdGet.Flags().BoolVar(&includeSecretGet, "include-secret", false, "with --json, also print the Base32 secret (dangerous; requires --confirm-include-secret)")
+	cmdGet.Flags().BoolVar(&confirmIncludeSecretGet, "confirm-include-secret", false, "confirm that --include-secret may print the secret")
+	cmdGet.Flags().IntVar(&offsetStepGet, "offset-step", 0, "print the code this many time steps from now (negative for the past)")
+	cmdGet.Flags().BoolVar(&clearOnExitGet, "clipboard-clear-on-exit", false, "with --watch --copy, clear the clipboard on exit if it still holds a copied code")
+	cmdGet.Flags().BoolVar(&waitGet, "wait", false, "wait for the next time step and print its fresh code")
+	cmdGet.Flags().IntVar(&minRemainingGet, "min-remaining", 0, "wait for the next time step only if fewer than this many seconds are left")
+	cmdGet.MarkFlagsMutuallyExclusive("wait", "min-remaining")
+	cmdGet.MarkFlagsMutuallyExclusive("wait", "offset-step", "statusbar", "verify-against", "watch")
+	cmdGet.MarkFlagsMutuallyExclusive("min-remaining", "offset-step", "statusbar", "verify-against", "watch")
+	cmdGet.Flags().BoolVar(&maskGet, "mask", false, "print the code as asterisks; with --copy, the real code only goes to the clipboard")
+	cmdGet.MarkFlagsMutuallyExclusive("mask", "json", "format", "verify-against")
+	cmdGet.Flags().BoolVar(&exitAtExpiryGet, "exit-at-expiry", false, "with --watch, exit as soon as the code shown expires")
+	cmdGet.Flags().BoolVar(&retryOnLockGet, "retry-on-lock", false, "if the keyring is locked, wait for Enter after unlocking it and retry (up to 3 times)")
+	cmdGet.Flags().StringVar(&alignGet, "time-step-boundary-wait", "", `with --watch, wait for the next whole second ("second") or time step ("step") before starting`)
+	cmdGet.Flags().Lookup("time-step-boundary-wait").NoOptDefVal = watchAlignSecond
+	cmdGet.MarkFlagsMutuallyExclusive("copy", "format", "statusbar", "verify-against", "json")
+	cmdGet.MarkFlagsMutuallyExclusive("watch", "format", "statusbar", "verify-against", "json")
+	cmdGet.MarkFlagsMutuallyExclusive("offset-step", "statusbar", "verify-against", "watch")
+	cmdGet.MarkFlagsMutuallyExclusive("show-name", "json")
+	cmdGet.MarkFlagsMutuallyExclusive("show-name", "format", "statusbar", "verify-against", "watch")
+	cmdGet.MarkFlagsMutuallyExclusive("check-time", "statusbar")
+	cmdGet.MarkFlagsMutuallyExclusive("retry-on-lock", "statusbar")
+	cmdGet.Flags().BoolVar(&allAlgorithmsGet, "all-algorithms", false, "debug aid: print the current code under sha1, sha256 and sha512, marking the stored algorithm")
+	for _, flag := range []string{"copy", "format", "json", "mask", "offset-step", "show-name", "statusbar", "verify-against", "wait", "min-remaining", "watch"} {
+		cmdGet.MarkFlagsMutuallyExclusive("all-algorithms", flag)
+	}
+	cmdGet.Flags().StringVar(&execGet, "exec", "", "run this shell command after printing the code, with the code in $TOTP_CODE and on its stdin")
+	cmdGet.Flags().DurationVar(&execTimeoutGet, "exec-timeout", defaultExecTimeout, "kill the --exec command if it runs longer than this")
+	for _, flag := range []string{"all-algorithms", "statusbar", "verify-against", "watch"} {
+		cmdGet.MarkFlagsMutuallyExclusive("exec", flag)
+	}
+	cmdGet.Flags().IntVar(&truncateToGet, "truncate-to", 0, "print only N characters of the code, for providers that want fewer digits than the entry stores")
+	cmdGet.Flags().StringVar(&truncateFromGet, "truncate-from", truncateRight, "with --truncate-to, keep the rightmost (right) or leftmost (left) characters")
+	cmdGet.RegisterFlagCompletionFunc("truncate-from", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{truncateRight, truncateLeft}, cobra.ShellCompDirectiveNoFileComp
+	})
+	for _, flag := range []string{"all-algorithms", "verify-against", "watch"} {
+		cmdGet.MarkFlagsMutuallyExclusive("truncate-to", flag)
+	}
+
+	var algorithmVerify string
+	var windowVerify int
+	var cmdVerify = &cobra.Command{
+		Use:   "verify <name> <code>",
+		Short: "Check a known-good code against an entry",
+		Long: `Check a code the service showed or accepted against an entry, searching
+--window time steps either side of now, and report the step it matches.
+
+--algorithm checks the code with another algorithm than the stored one.
+--algorithm auto tries sha1, sha256 and sha512 in turn and reports which
+matched, for entries set up with the wrong algorithm; if it is not the stored
+one, the edit command that fixes the entry is suggested. If more than one
+matches, the secret's length decides which is more likely.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, err := resolveName(args[0])
+			if err != nil {
+				return err
+			}
+			a, err := getUnlockedItem(name)
+			if err != nil {
+				return err
+			}
+			if a.Type == accountTypeHOTP {
+				return errors.New("verify only works with TOTP entries")
+			}
+
+			algorithms := []string{a.Algorithm}
+			switch algorithmVerify {
+			case "":
+			case "auto":
+				algorithms = algorithmNames
+			default:
+				if _, err := hashFunc(algorithmVerify); err != nil {
+					return err
+				}
+				algorithms = []string{algorithmVerify}
+			}
+
+			code := strings.TrimSpace(args[1])
+			now := accountTime(a, time.Now())
+			if len(algorithms) == 1 {
+				a.Algorithm = algorithms[0]
+				return verifyCode(a, code, now, windowVerify)
+			}
+
+			stored := strings.ToLower(a.Algorithm)
+			var matched []string
+			for _, alg := range algorithms {
+				try := a
+				try.Algorithm = alg
+				offset, ok, err := try.matchOffset(code, now, windowVerify)
+				if err != nil {
+					return err
+				}
+				result := "no match"
+				if ok {
+					matched = append(matched, alg)
+					result = describeOffset(offset, a.Period)
+				}
+				fmt.Printf("%-7v %v\n", alg+":", result)
+			}
+
+			var best string
+			switch len(matched) {
+			case 0:
+				return fmt.Errorf("Code does not match with any of %v within ±%v steps; check the digits and period too", strings.Join(algorithms, ", "), windowVerify)
+			case 1:
+				best = matched[0]
+			default:
+				key, err := decodeSecret(a.Secret)
+				if err != nil {
+					return err
+				}
+				likely := likelyAlgorithm(len(key))
+				wipe(key)
+				best = matched[0]
+				if slices.Contains(matched, likely) {
+					best = likely
+				}
+				fmt.Printf("Several algorithms match; %v is the most likely for a %d-byte secret. Verify the next code to be sure.\n", best, len(key))
+			}
+			if best == stored {
+				fmt.Printf("The stored algorithm (%v) is correct.\n", stored)
+				return nil
+			}
+			fmt.Printf("The entry is stored with %v. Fix it with:\n  totp edit --algorithm %v %v\n", stored, best, name)
+			return nil
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return completeNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	cmdVerify.Flags().StringVar(&algorithmVerify, "algorithm", "", "check with this algorithm instead of the stored one; auto tries sha1, sha256 and sha512")
+	cmdVerify.RegisterFlagCompletionFunc("algorithm", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return append([]string{"auto"}, algorithmNames...), cobra.ShellCompDirectiveNoFileComp
+	})
+	cmdVerify.Flags().IntVar(&windowVerify, "window", 3, "number of steps either side of now to search")
+
+	var cmdCopy = &cobra.Command{
+		Use:   "copy <name>...",
+		Short: "Copy the codes of one or more entries to the clipboard",
+		Long: `Copy the current codes of the given entries to the clipboard, one per
+line in the order given. Only the names are printed, never the codes.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			now := time.Now()
+			codes := make([]string, 0, len(args))
+			names := make([]string, 0, len(args))
+			for _, arg := range args {
+				info, err := currentCode(arg, now)
+				if err != nil {
+					return fmt.Errorf("%v: %w", arg, err)
+				}
+				codes = append(codes, info.Code)
+				names = append(names, info.Name)
+			}
+
+			if err := clipboard.WriteAll(strings.Join(codes, "\n")); err != nil {
+				return fmt.Errorf("copy failed: %w", err)
+			}
+			quoted := make([]string, len(names))
+			for i, name := range names {
+				_ = recordUse(name)
+				quoted[i] = fmt.Sprintf("\"%v\"", name)
+			}
+			infof("Copied codes for %v.\n", strings.Join(quoted, ", "))
+			return nil
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return completeNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	var cmdNext = &cobra.Command{
+		Use:   "next <name>",
+		Short: "Print the next code of an HOTP entry",
+		Long: `Print the code for the current counter of a counter-based (HOTP) entry and
+advance the counter. get does the same for HOTP entries; next refuses
+time-based ones, so scripts never mix the two up.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, err := resolveName(args[0])
+			if err != nil {
+				return err
+			}
+			a, err := getUnlockedItem(name)
+			if err != nil {
+				return err
+			}
+			if a.Type != accountTypeHOTP {
+				return fmt.Errorf("\"%v\" is not an HOTP entry; use get", name)
+			}
+			code, err := nextHOTPCode(name, a.Secret)
+			if err != nil {
+				return err
+			}
+			_ = recordUse(name)
+			fmt.Println(code)
+			return nil
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	var cmdSetCounter = &cobra.Command{
+		Use:   "set-counter <name> <counter>",
+		Short: "Set the counter of an HOTP entry",
+		Long: `Set the counter of a counter-based (HOTP) entry, e.g. to resync it with a
+server that has drifted ahead. The next code is generated for this counter.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			counter, err := strconv.ParseUint(args[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid counter: %q (expected a non-negative integer)", args[1])
+			}
+			name, err := resolveName(args[0])
+			if err != nil {
+				return err
+			}
+			if err := setHOTPCounter(name, counter); err != nil {
+				return err
+			}
+			infof("Counter of \"%v\" set to %d.\n", name, counter)
+			return nil
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return completeNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	var yesDelete, keyringOnlyDelete, indexOnlyDelete bool
+	var cmdDelete = &cobra.Command{
+		Use:   "delete <name|pattern>...",
+		Short: "Delete TOTP codes",
+		Long: `Delete one or more entries from the system keyring.
+
+Arguments may be glob patterns (*, ? and [...], quoted so the shell leaves
+them alone), which are matched against the indexed names. Deleting more than
+one entry asks for confirmation unless --yes is given. Names that are not
+found are reported and skipped; the exit status is non-zero only if a
+deletion fails for another reason.
+
+To repair drift between the keyring and the index (~/.totp.json),
+--keyring-only deletes the secret but leaves the indexed name, and
+--index-only removes the name from the index but keeps the secret.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names, unmatched, err := expandNames(args)
+			if err != nil {
+				return err
+			}
+			for _, pattern := range unmatched {
+				if !quiet {
+					fmt.Fprintf(os.Stderr, "No names match \"%v\".\n", pattern)
+				}
+			}
+			if len(names) > 1 && !yesDelete {
+				fmt.Fprintf(os.Stderr, "This will delete %d entries: %v\n", len(names), strings.Join(names, ", "))
+				ok, err := confirm("Continue?")
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return errors.New("Aborted")
+				}
+			}
+
+			remove, from := deleteItem, ""
+			if keyringOnlyDelete {
+				remove, from = keyringDelete, " from the keyring only"
+			} else if indexOnlyDelete {
+				remove, from = deleteFromIndex, " from the index only"
+			}
+
+			// The index-only mode keeps the secret, so there is nothing to
+			// put in the trash.
+			var trashed []trashItem
+			var trashedValues []string
+			var deleted, notFound, failed int
+			for _, name := range names {
+				var items []trashItem
+				var values []string
+				if !indexOnlyDelete {
+					items, values = trashItemsFor([]string{name})
+				}
+				err := remove(name)
+				switch {
+				case err == nil:
+					deleted++
+					trashed = append(trashed, items...)
+					trashedValues = append(trashedValues, values...)
+					infof("Successfully deleted \"%v\"%v.\n", name, from)
+				case errors.Is(err, keyring.ErrNotFound), errors.Is(err, ErrNameNotFound):
+					notFound++
+					fmt.Fprintf(os.Stderr, "\"%v\" is not found.\n", name)
+				default:
+					failed++
+					fmt.Fprintf(os.Stderr, "Failed to delete \"%v\": %v\n", name, err)
+				}
+			}
+
+			if len(names) > 1 {
+				infof("Deleted %d, not found %d, failed %d.\n", deleted, notFound, failed)
+			}
+			saveTrash(trashDelete, trashed, trashedValues)
+			if failed > 0 {
+				return fmt.Errorf("Failed to delete %d of %d entries", failed, len(names))
+			}
+			return nil
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			var out []string
+			for _, name := range completeNames(toComplete) {
+				if !slices.Contains(args, name) {
+					out = append(out, name)
+				}
+			}
+			return out, cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	cmdDelete.Flags().BoolVarP(&yesDelete, "yes", "y", false, "do not ask for confirmation when deleting several entries")
+	cmdDelete.Flags().BoolVar(&keyringOnlyDelete, "keyring-only", false, "delete the secret from the keyring but keep the name in the index")
+	cmdDelete.Flags().BoolVar(&indexOnlyDelete, "index-only", false, "remove the name from the index but keep the secret in the keyring")
+	cmdDelete.MarkFlagsMutuallyExclusive("keyring-only", "index-only")
+
+	var regexRename, yesRename bool
+	var cmdRename = &cobra.Command{
+		Use:   "rename <old> <new>",
+		Short: "Rename an entry",
+		Long: `Rename an entry, keeping its secret and metadata.
+
+With --regex, <old> is a regular expression matched against every indexed
+name and <new> its replacement ($1 etc. refer to capture groups). The planned
+renames are shown and must be confirmed unless --yes is given. Nothing is
+renamed if any new name already exists or two names would collide, and a
+failure part-way through puts every entry back.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var pairs []renamePair
+			if regexRename {
+				re, err := regexp.Compile(args[0])
+				if err != nil {
+					return fmt.Errorf("invalid --regex pattern: %w", err)
+				}
+				pairs, err = planRegexRename(re, args[1])
+				if err != nil {
+					return err
+				}
+				if len(pairs) == 0 {
+					infof("No names to rename.\n")
+					return nil
+				}
+			} else {
+				from, err := resolveName(args[0])
+				if err != nil {
+					return err
+				}
+				exists, err := nameExists(from)
+				if err != nil {
+					return err
+				}
+				if !exists {
+					return ErrNameNotFound
+				}
+				pairs = []renamePair{{From: from, To: args[1]}}
+			}
+
+			if err := checkRenames(pairs); err != nil {
+				return err
+			}
+
+			if regexRename {
+				for _, p := range pairs {
+					fmt.Fprintf(os.Stderr, "%v -> %v\n", p.From, p.To)
+				}
+				if !yesRename {
+					ok, err := confirm(fmt.Sprintf("Rename %d entries?", len(pairs)))
+					if err != nil {
+						return err
+					}
+					if !ok {
+						return errors.New("Aborted")
+					}
+				}
+			}
+
+			if err := renameItems(pairs); err != nil {
+				return err
+			}
+			items := make([]trashItem, len(pairs))
+			for i, p := range pairs {
+				infof("Successfully renamed \"%v\" to \"%v\".\n", p.From, p.To)
+				items[i] = trashItem{Name: p.From, NewName: p.To}
+			}
+			saveTrash(trashRename, items, nil)
+			return nil
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 || regexRename {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return completeNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	cmdRename.Flags().BoolVar(&regexRename, "regex", false, "treat <old> as a regular expression and <new> as its replacement, renaming every match")
+	cmdRename.Flags().BoolVarP(&yesRename, "yes", "y", false, "do not ask for confirmation with --regex")
+
+	var algorithmEdit, issuerEdit, accountEdit string
+	var digitsEdit, periodEdit int
+	var baseTimeEdit, timeSourceEdit string
+	var tagsEdit []string
+	var cmdEdit = &cobra.Command{
+		Use:   "edit <name>",
+		Short: "Change the parameters of an entry, keeping its secret",
+		Long: `Change the algorithm, digits, period, base time, time source, issuer,
+account label or tags of an existing entry without entering its secret again,
+e.g. to fix an import that used the wrong defaults. Only the given flags
+change anything; --tag replaces all tags, and --tag "" removes them.
+--time-source "" goes back to the local clock.
+
+The resulting code is shown so you can compare it with the service.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			changed := cmd.Flags().Changed
+			if !changed("algorithm") && !changed("digits") && !changed("period") && !changed("base-time") &&
+				!changed("time-source") && !changed("issuer") && !changed("account") && !changed("tag") {
+				return errors.New("Nothing to change: pass --algorithm, --digits, --period, --base-time, --time-source, --issuer, --account or --tag")
+			}
+
+			name, err := resolveName(args[0])
+			if err != nil {
+				return err
+			}
+			a, err := getItem(name)
+			if err != nil {
+				return err
+			}
+
+			if changed("algorithm") {
+				a.Algorithm = strings.ToUpper(algorithmEdit)
+			}
+			if changed("digits") {
+				a.Digits = digitsEdit
+			}
+			if changed("period") {
+				a.Period = periodEdit
+			}
+			if changed("base-time") {
+				if a.T0, err = parseBaseTime(baseTimeEdit); err != nil {
+					return err
+				}
+			}
+			if changed("time-source") {
+				if timeSourceEdit != "" {
+					if err := checkTimeSource(timeSourceEdit); err != nil {
+						return err
+					}
+				}
+				a.TimeSource = timeSourceEdit
+			}
+			if changed("issuer") {
+				a.Issuer = issuerEdit
+			}
+			if changed("account") {
+				a.Account = accountEdit
+			}
+			if changed("tag") {
+				a.Tags = slices.DeleteFunc(slices.Clone(tagsEdit), func(tag string) bool { return tag == "" })
+			}
+			if err := checkParams(a.Algorithm, a.Digits, a.Period); err != nil {
+				return err
+			}
+
+			trashed, trashedValues := trashItemsFor([]string{name})
+			if err := addItem(name, a); err != nil {
+				return err
+			}
+			infof("Successfully updated \"%v\".\n", name)
+			saveTrash(trashEdit, trashed, trashedValues)
+
+			if a.Type == accountTypeHOTP {
+				return nil
+			}
+			if a.Protected != nil {
+				if a, err = getUnlockedItem(name); err != nil {
+					return err
+				}
+			}
+			code, err := a.code(accountTime(a, time.Now()))
+			if err != nil {
+				return err
+			}
+			if params := a.paramsSummary(); params != "" {
+				infof("Parameters: %v\n", params)
+			}
+			printData("Current code", code)
+			return nil
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return completeNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	cmdEdit.Flags().StringVar(&algorithmEdit, "algorithm", "", "new HMAC algorithm: sha1, sha256 or sha512")
+	cmdEdit.Flags().IntVar(&digitsEdit, "digits", 0, "new number of digits in a code")
+	cmdEdit.Flags().IntVar(&periodEdit, "period", 0, "new number of seconds each code is valid for")
+	cmdEdit.Flags().StringVar(&baseTimeEdit, "base-time", "", "new time steps are counted from (T0), as Unix seconds or RFC 3339")
+	cmdEdit.Flags().StringVar(&timeSourceEdit, "time-source", "", "new NTP server or http(s) URL to compute codes against; empty for the local clock")
+	cmdEdit.Flags().StringVar(&issuerEdit, "issuer", "", "new issuer (service provider); empty to remove it")
+	cmdEdit.Flags().StringVar(&accountEdit, "account", "", "new account (user) label; empty to remove it")
+	cmdEdit.Flags().StringArrayVar(&tagsEdit, "tag", nil, "tag replacing the current ones (repeatable); empty to remove all")
+	cmdEdit.RegisterFlagCompletionFunc("algorithm", completeAlgorithms)
+	cmdEdit.RegisterFlagCompletionFunc("issuer", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeIndexValues(func(e indexEntry) []string { return []string{e.Issuer} }), cobra.ShellCompDirectiveNoFileComp
+	})
+	cmdEdit.RegisterFlagCompletionFunc("tag", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeIndexValues(func(e indexEntry) []string { return e.Tags }), cobra.ShellCompDirectiveNoFileComp
+	})
+
+	var dryRunPrune bool
+	var cmdPrune = &cobra.Command{
+		Use:   "prune",
+		Short: "Remove index names whose keyring entry is gone",
+		Long: `Remove names from the index (~/.totp.json) whose keyring entry no longer
+exists, e.g. because it was deleted with another tool. Each removed name is
+printed. The keyring itself is never modified.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, missing, err := splitIndexNames()
+			if err != nil {
+				return err
+			}
+			if len(missing) == 0 {
+				infof("Nothing to prune.\n")
+				return nil
+			}
+
+			names := make([]string, 0, len(missing))
+			for name := range missing {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			if dryRunPrune {
+				for _, name := range names {
+					fmt.Printf("Would remove \"%v\".\n", name)
+				}
+				return nil
+			}
+
+			if err := removeNamesFromIndex(missing); err != nil {
+				return err
+			}
+			for _, name := range names {
+				infof("Removed \"%v\".\n", name)
+			}
+			return nil
+		},
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+
+	cmdPrune.Flags().BoolVarP(&dryRunPrune, "dry-run", "n", false, "only print the names that would be removed")
+
+	var cmdValidate = &cobra.Command{
+		Use:   "validate [secret]",
+		Short: "Check whether a secret is valid Base32",
+		Long: `Check whether a secret is valid Base32, without storing anything. The
+secret is taken from the argument, or read from standard input (prompting on
+a terminal). Prints the normalized secret and the length of the decoded key.
+Exits non-zero if the secret is invalid.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var secret string
+			if len(args) == 1 {
+				secret = args[0]
+			} else {
+				var err error
+				if secret, err = readLine("Type secret: "); err != nil {
+					return err
+				}
+			}
+
+			normalized, err := normalizeAndValidateSecret(secret)
+			if err != nil {
+				return err
+			}
+			key, err := decodeSecret(normalized)
+			if err != nil {
+				return err
+			}
+			n := len(key)
+			wipe(key)
+
+			fmt.Printf("Valid secret: %v (%d bytes)\n", normalized, n)
+			return nil
+		},
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+
+	var copyTemp bool
+	var algorithmTemp string
+	var digitsTemp, periodTemp int
+	var baseTimeTemp string
+	var qrTemp bool
+	var outputFormatTemp, issuerTemp, accountTemp string
+	var cmdTemp = &cobra.Command{
+		Use:   "temp",
+		Short: "Get a TOTP code from a secret without saving it to the keyring",
+		Long: `Get a TOTP code from a secret without saving it to the keyring.
+
+Either a Base32 secret or a full otpauth://totp/ URI can be typed. Parameters
+encoded in the URI are honored; flags given explicitly override them.
+
+--qr shows the secret and its parameters as a QR code instead, e.g. to set up
+a phone, still without storing anything. --issuer and --account set the label
+the authenticator app shows (the account defaults to "temp"). The QR code
+contains the secret: anyone who sees it can generate your codes.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !qrTemp {
+				for _, flag := range []string{"output-format", "issuer", "account"} {
+					if cmd.Flags().Changed(flag) {
+						return fmt.Errorf("--%v requires --qr", flag)
+					}
+				}
+			} else if baseTimeTemp != "" {
+				return errors.New("--base-time cannot be carried in a QR code")
+			}
+
+			input, err := readLine("Type secret: ")
+			if err != nil {
+				return err
+			}
+			input = strings.TrimSpace(input)
+
+			var a account
+			if strings.HasPrefix(strings.ToLower(input), "otpauth://") {
+				var err error
+				a, err = parseOTPAuthURL(input)
+				if errors.Is(err, errNotOTP) {
+					return errors.New("Given URI is not for TOTP")
+				}
+				if err != nil {
+					return err
+				}
+				if a.Type == accountTypeHOTP {
+					return errors.New("temp only supports TOTP; use add or scan to store an HOTP entry")
+				}
+			} else {
+				secret, err := normalizeAndValidateSecret(input)
+				if err != nil {
+					return err
+				}
+				a = newAccount(secret)
+			}
+
+			if cmd.Flags().Changed("algorithm") {
+				a.Algorithm = strings.ToUpper(algorithmTemp)
+			}
+			if cmd.Flags().Changed("digits") {
+				a.Digits = digitsTemp
+			}
+			if cmd.Flags().Changed("period") {
+				a.Period = periodTemp
+			}
+			if baseTimeTemp != "" {
+				if a.T0, err = parseBaseTime(baseTimeTemp); err != nil {
+					return err
+				}
+			}
+			if err := checkParams(a.Algorithm, a.Digits, a.Period); err != nil {
+				return err
+			}
+
+			if qrTemp {
+				if cmd.Flags().Changed("issuer") {
+					a.Issuer = issuerTemp
+				}
+				if cmd.Flags().Changed("account") {
+					a.Account = accountTemp
+				}
+				uri, err := buildOTPAuthURL("temp", a, labelFormatIssuerAccount)
+				if err != nil {
+					return err
+				}
+				return renderQR(os.Stdout, uri, outputFormatTemp)
+			}
+
+			code, err := a.code(time.Now())
+			if err != nil {
+				return err
+			}
+			return outputCode(code, copyTemp, false)
+		},
+		ValidArgsFunction: cobra.NoFileCompletions,
+	}
+
+	cmdTemp.Flags().BoolVarP(&copyTemp, "copy", "c", false, "copy the current code to the clipboard")
+	cmdTemp.Flags().StringVar(&algorithmTemp, "algorithm", "sha1", "HMAC algorithm: sha1, sha256 or sha512")
+	cmdTemp.Flags().IntVar(&digitsTemp, "digits", defaultDigits, "number of digits in a code")
+	cmdTemp.Flags().IntVar(&periodTemp, "period", defaultPeriod, "seconds each code is valid for")
+	cmdTemp.Flags().StringVar(&baseTimeTemp, "base-time", "", "time steps are counted from (T0), as Unix seconds or RFC 3339")
+	cmdTemp.Flags().BoolVar(&qrTemp, "qr", false, "show the secret as a QR code in the terminal instead of a code")
+	cmdTemp.Flags().StringVar(&outputFormatTemp, "output-format", defaultQRFormat(), "QR code rendering with --qr: qr-utf8 or qr-ascii")
+	cmdTemp.Flags().StringVar(&issuerTemp, "issuer", "", "issuer to put in the QR code's label (with --qr)")
+	cmdTemp.Flags().StringVar(&accountTemp, "account", "", "account to put in the QR code's label (with --qr)")
+	cmdTemp.MarkFlagsMutuallyExclusive("qr", "copy")
+	cmdTemp.RegisterFlagCompletionFunc("algorithm", completeAlgorithms)
+	cmdTemp.RegisterFlagCompletionFunc("output-format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{qrFormatUTF8, qrFormatASCII}, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	var forceShowSecret bool
+	var cmdShowSecret = &cobra.Command{
+		Use:   "show-secret <name>",
+		Short: "Print the stored Base32 secret",
+		Long: `Print the stored Base32 secret, e.g. to set up another device.
+
+The secret is sensitive: anyone who sees it can generate your codes.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+
+			a, err := getItem(name)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(os.Stderr, "Warning: the secret lets anyone generate your codes. Do not share it.")
+			if !forceShowSecret {
+				ok, err := confirm(fmt.Sprintf("Show the secret for \"%v\"?", name))
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return errors.New("Aborted")
+				}
+			}
+
+			if a.Protected != nil {
+				if a, err = getUnlockedItem(name); err != nil {
+					return err
+				}
+			}
+			fmt.Println(a.Secret)
+			return nil
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+
+			return completeNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	cmdShowSecret.Flags().BoolVarP(&forceShowSecret, "force", "f", false, "do not ask for confirmation")
+
+	var cmdMigrate = &cobra.Command{
+		Use:   "migrate",
+		Short: "Upgrade legacy keyring entries to the current format",
+		Long: `Upgrade every registered entry that still stores a bare Base32 secret to the
+current versioned format, using the default parameters. Running it again is a
+no-op.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names, err := listItems()
+			if err != nil {
+				return err
+			}
 
-	return idx.Names, nil
-}
+			upgraded := 0
+			for _, name := range names {
+				ok, err := migrateItem(name)
+				if err != nil {
+					return fmt.Errorf("%v: %w", name, err)
+				}
+				if ok {
+					upgraded++
+				}
+			}
 
-func nameExists(name string) (bool, error) {
-	_, err := keyring.Get(serviceName, name)
-	if err == nil {
-		return true, nil
+			infof("Upgraded %v of %v entries.\n", upgraded, len(names))
+			return nil
+		},
+		ValidArgsFunction: cobra.NoFileCompletions,
 	}
-	if errors.Is(err, keyring.ErrNotFound) {
-		return false, nil
+
+	var fixDoctor, yesDoctor bool
+	var cmdDoctor = &cobra.Command{
+		Use:   "doctor",
+		Short: "Check for common problems and optionally repair them",
+		Long: `Check for common problems: a corrupt index, index names whose keyring entry
+is gone, and entries still in the legacy format. Each problem is printed with
+its fix; the exit status is non-zero if any was found.
+
+--fix applies the fixes, asking before each unless --yes: a corrupt index is
+moved aside (to <index>.bak) and rebuilt from the keyring where the backend
+can be enumerated, dangling names are removed as by "totp prune", and legacy
+entries are upgraded as by "totp migrate".`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if yesDoctor && !fixDoctor {
+				return errors.New("--yes requires --fix")
+			}
+			if fixDoctor && readOnly {
+				return errors.New("--fix cannot repair anything with --read-only")
+			}
+			return runDoctor(fixDoctor, yesDoctor)
+		},
+		ValidArgsFunction: cobra.NoFileCompletions,
 	}
-	return false, err
-}
 
-func promptNewName(initial string) (string, error) {
-	reader := bufio.NewReader(os.Stdin)
-	name := initial
-	for {
-		exists, err := nameExists(name)
-		if err != nil {
-			return "", err
-		}
-		if !exists {
-			return name, nil
-		}
+	cmdDoctor.Flags().BoolVar(&fixDoctor, "fix", false, "repair the problems found")
+	cmdDoctor.Flags().BoolVarP(&yesDoctor, "yes", "y", false, "do not ask before each repair")
 
-		fmt.Printf("Name \"%v\" already exists. Type new name: ", name)
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			continue
-		}
-		name = strings.TrimSpace(line)
-		if name == "" {
-			continue
-		}
+	var jsonStats bool
+	var cmdStats = &cobra.Command{
+		Use:   "stats",
+		Short: "Summarize the registered entries",
+		Long: `Print an overview of the registered entries: how many there are, how many
+are HOTP, protected or still in the legacy format, and how they break down by
+issuer, algorithm, digits and period. Nothing is unlocked or modified, so
+legacy entries stay as they are until "totp migrate", and names whose
+keyring entry is gone are reported rather than pruned.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names, err := listIndexNames()
+			if err != nil {
+				return err
+			}
+
+			s := collectStats(names)
+			if jsonStats {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				return enc.Encode(s)
+			}
+			return s.writeReport(os.Stdout)
+		},
+		ValidArgsFunction: cobra.NoFileCompletions,
 	}
-}
 
-func main() {
-	var useBarcodeHintWhenScan bool
+	cmdStats.Flags().BoolVar(&jsonStats, "json", false, "print the report as a JSON object")
 
-	var cmdScan = &cobra.Command{
-		Use:   "scan <name> <image>",
-		Short: "Scan a QR code image",
-		Long:  `Scan a QR code image and store it to the system keyring.`,
-		Args:  cobra.ExactArgs(2),
+	var labelFormatURI string
+	var cmdURI = &cobra.Command{
+		Use:   "uri <name>",
+		Short: "Print the otpauth:// URI of an entry",
+		Long: `Print the otpauth:// URI of an entry, e.g. to move it to another app.
 
+The URI contains the secret: anyone who sees it can generate your codes.`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			name := args[0]
-			path := args[1]
-
-			// open and decode image file
-			file, err := os.Open(path)
+			name, err := resolveName(args[0])
 			if err != nil {
 				return err
 			}
-			img, _, err := image.Decode(file)
+
+			a, err := getUnlockedItem(name)
 			if err != nil {
 				return err
 			}
 
-			// prepare BinaryBitmap
-			bmp, err := gozxing.NewBinaryBitmapFromImage(img)
+			uri, err := buildOTPAuthURL(name, a, labelFormatURI)
 			if err != nil {
 				return err
 			}
+			fmt.Println(uri)
+			return nil
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
 
-			// decode image
-			qrReader := qrcode.NewQRCodeReader()
+			return completeNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+		},
+	}
 
-			var hint map[gozxing.DecodeHintType]interface{}
-			if useBarcodeHintWhenScan {
-				hint = map[gozxing.DecodeHintType]interface{}{
-					gozxing.DecodeHintType_PURE_BARCODE: struct{}{},
-				}
-			}
+	var labelFormatQR string
+	var outputFormatQR string
+	var cmdQR = &cobra.Command{
+		Use:   "qr <name>",
+		Short: "Show an entry as a QR code in the terminal",
+		Long: `Show an entry as a QR code in the terminal, to scan it with a phone.
 
-			result, err := qrReader.Decode(bmp, hint)
+The QR code contains the secret: anyone who sees it can generate your codes.
+
+--output-format qr-utf8 draws compact Unicode half blocks; qr-ascii uses two
+"#" characters per module for terminals without them. The default depends on
+whether the locale is UTF-8.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name, err := resolveName(args[0])
 			if err != nil {
 				return err
 			}
 
-			// parse TOTP URL
-			parsed, err := url.Parse(result.GetText())
+			a, err := getUnlockedItem(name)
 			if err != nil {
 				return err
 			}
-			secret, err := normalizeAndValidateSecret(parsed.Query().Get("secret"))
+
+			uri, err := buildOTPAuthURL(name, a, labelFormatQR)
 			if err != nil {
 				return err
 			}
-			if parsed.Scheme != "otpauth" || parsed.Host != "totp" {
-				return errors.New("Given QR code is not for TOTP")
+			return renderQR(os.Stdout, uri, outputFormatQR)
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
 			}
 
-			name, err = promptNewName(name)
+			return completeNames(toComplete), cobra.ShellCompDirectiveNoFileComp
+		},
+	}
+
+	for _, c := range []struct {
+		cmd         *cobra.Command
+		labelFormat *string
+	}{{cmdURI, &labelFormatURI}, {cmdQR, &labelFormatQR}} {
+		c.cmd.Flags().StringVar(
+			c.labelFormat,
+			"label-format",
+			labelFormatIssuerAccount,
+			`label format: "issuer-account" (Issuer:Account) or "account" (Account, issuer only as a parameter)`,
+		)
+		c.cmd.RegisterFlagCompletionFunc("label-format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			return []string{labelFormatIssuerAccount, labelFormatAccount}, cobra.ShellCompDirectiveNoFileComp
+		})
+	}
+
+	cmdQR.Flags().StringVar(&outputFormatQR, "output-format", defaultQRFormat(), "terminal rendering: qr-utf8 or qr-ascii")
+	cmdQR.RegisterFlagCompletionFunc("output-format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{qrFormatUTF8, qrFormatASCII}, cobra.ShellCompDirectiveNoFileComp
+	})
+
+	var tagsExport []string
+	var formatExport, labelFormatExport string
+	var encryptExportFlag bool
+	var cmdExport = &cobra.Command{
+		Use:   "export [name|pattern...]",
+		Short: "Export entries as otpauth:// URIs or JSON",
+		Long: `Export entries, e.g. to move them to another machine or app, or to hand one
+account's setup to a colleague.
+
+Without arguments every entry is exported. Otherwise only the given names and
+glob patterns are; --tag further narrows the selection to entries with any of
+the given tags. It is an error if nothing matches.
+
+--format uri prints one otpauth:// URI per line; --format json prints every
+parameter, including backup secrets. --encrypt seals either under a new
+passphrase. Protected entries are unlocked with their own passphrase first.
+
+The output contains the secrets: anyone who sees it can generate your codes.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			names, err := selectExportNames(args, tagsExport)
 			if err != nil {
 				return err
 			}
 
-			err = addItem(name, secret)
+			out, err := exportItems(names, formatExport, labelFormatExport)
 			if err != nil {
 				return err
 			}
-			fmt.Printf("Given QR code successfully registered as \"%v\".\n", name)
+			if encryptExportFlag {
+				passphrase, err := readNewPassphrase()
+				if err != nil {
+					return err
+				}
+				defer wipe(passphrase)
+				if out, err = encryptExport(out, formatExport, passphrase); err != nil {
+					return err
+				}
+			}
+
+			fmt.Print(out)
+			if !quiet {
+				fmt.Fprintf(os.Stderr, "Exported %v entries.\n", len(names))
+			}
 			return nil
 		},
 		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-			if len(args) == 1 {
-				return nil, cobra.ShellCompDirectiveDefault
+			var out []string
+			for _, name := range completeNames(toComplete) {
+				if !slices.Contains(args, name) {
+					out = append(out, name)
+				}
 			}
-
-			return nil, cobra.ShellCompDirectiveNoFileComp
+			return out, cobra.ShellCompDirectiveNoFileComp
 		},
 	}
 
-	cmdScan.Flags().BoolVarP(
-		&useBarcodeHintWhenScan,
-		"barcode",
-		"b",
-		false,
-		"use PURE_BARCODE hint for decoding. this flag maybe solves FormatException",
-	)
+	cmdExport.Flags().StringArrayVar(&tagsExport, "tag", nil, "only export entries with this tag (repeatable)")
+	cmdExport.Flags().StringVar(&formatExport, "format", exportFormatURI, "output format: uri or json")
+	cmdExport.Flags().StringVar(&labelFormatExport, "label-format", labelFormatIssuerAccount, `URI label format: "issuer-account" or "account"`)
+	cmdExport.Flags().BoolVar(&encryptExportFlag, "encrypt", false, "encrypt the export with a passphrase")
+	cmdExport.RegisterFlagCompletionFunc("tag", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return completeIndexValues(func(e indexEntry) []string { return e.Tags }), cobra.ShellCompDirectiveNoFileComp
+	})
+	cmdExport.RegisterFlagCompletionFunc("format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{exportFormatURI, exportFormatJSON}, cobra.ShellCompDirectiveNoFileComp
+	})
+	cmdExport.RegisterFlagCompletionFunc("label-format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{labelFormatIssuerAccount, labelFormatAccount}, cobra.ShellCompDirectiveNoFileComp
+	})
 
-	var copyAdd bool
-	var cmdAdd = &cobra.Command{
-		Use:   "add <name>",
-		Short: "Manually add a secret to the system keyring",
-		Args:  cobra.ExactArgs(1),
+	var toKeyringExportBackup bool
+	var cmdBackup = &cobra.Command{
+		Use:   "backup <file>",
+		Short: "Back up every entry to an encrypted file",
+		Long: `Back up every entry to <file>, encrypted under a new passphrase. The file
+is what "totp export --format json --encrypt" prints; it must not exist yet.
+
+--to-keyring-export uses the platform's native export instead where there is
+one, to fit into backup workflows you already use. On macOS with the keychain
+backend, <file> becomes a keychain file locked with the passphrase, holding a
+copy of every totp keychain item as stored (protected entries stay
+protected); open it in Keychain Access to restore items. Elsewhere a note is
+printed and the encrypted file is written as usual.`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			name, err := promptNewName(args[0])
+			path := args[0]
+			if _, err := os.Stat(path); err == nil {
+				return fmt.Errorf("%v already exists; choose another path or delete it", path)
+			}
+			names, err := selectExportNames(nil, nil)
 			if err != nil {
 				return err
 			}
 
-			// Read secret from stdin
-			var secret string
-			fmt.Print("Type secret: ")
-			fmt.Scanln(&secret)
+			if toKeyringExportBackup {
+				if nativeExportAvailable() {
+					passphrase, err := readNewPassphrase()
+					if err != nil {
+						return err
+					}
+					defer wipe(passphrase)
+					if err := nativeExport(path, names, passphrase); err != nil {
+						return err
+					}
+					infof("Backed up %d entries to %v (%v).\n", len(names), path, nativeExportKind)
+					return nil
+				}
+				if !quiet {
+					fmt.Fprintln(os.Stderr, "Note: native keyring export is not available with this platform or keyring backend; writing an encrypted file instead.")
+				}
+			}
 
-			secret, err = normalizeAndValidateSecret(secret)
+			out, err := exportItems(names, exportFormatJSON, labelFormatIssuerAccount)
 			if err != nil {
 				return err
 			}
-
-			code := gotp.NewDefaultTOTP(secret).Now()
-			if copyAdd {
-				fmt.Print("Current code: ")
-				if err := outputCode(code, true); err != nil {
-					return err
-				}
-			} else {
-				fmt.Printf("Current code: %v\n", code)
+			passphrase, err := readNewPassphrase()
+			if err != nil {
+				return err
+			}
+			defer wipe(passphrase)
+			if out, err = encryptExport(out, exportFormatJSON, passphrase); err != nil {
+				return err
 			}
 
-			err = addItem(name, secret)
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
 			if err != nil {
 				return err
 			}
-			fmt.Printf("Given secret successfully registered as \"%v\".\n", name)
+			if _, err := f.WriteString(out); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+			infof("Backed up %d entries to %v.\n", len(names), path)
 			return nil
 		},
-		ValidArgsFunction: cobra.NoFileCompletions,
 	}
 
-	cmdAdd.Flags().BoolVarP(&copyAdd, "copy", "c", false, "copy the current code to the clipboard")
+	cmdBackup.Flags().BoolVar(&toKeyringExportBackup, "to-keyring-export", false, "use the platform's native keyring export (a keychain file on macOS) where available")
 
-	var cmdList = &cobra.Command{
+	var profileName string
+	var cmdProfile = &cobra.Command{
+		Use:   "profile",
+		Short: "Inspect profiles",
+		Long: `Profiles keep separate sets of entries, each with its own keyring service
+and index file. They are configured in ~/.config/totp/config.json (or
+~/.totp-config.json):
+
+  {
+    "profiles": {
+      "work": {"service": "totp-work", "index": "~/.totp-work.json"}
+    }
+  }
+
+Both fields are optional and default to those shown. Select a profile with
+--profile or TOTP_PROFILE.`,
+		Args: cobra.NoArgs,
+	}
+	cmdProfile.AddCommand(&cobra.Command{
 		Use:   "list",
-		Short: "List all registered TOTP codes",
+		Short: "List the available profiles",
 		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			names, err := listItems()
+			c, err := readConfig()
 			if err != nil {
 				return err
 			}
 
-			for _, name := range names {
-				fmt.Println(name)
+			active := profileName
+			if active == "" {
+				active = defaultProfileName
 			}
-			return nil
+			w := newTableWriter(os.Stdout, 2)
+			fmt.Fprintln(w, "\tNAME\tSERVICE\tINDEX")
+			for _, name := range profileNames(c) {
+				p, err := resolveProfile(c, name)
+				if err != nil {
+					return err
+				}
+				mark := ""
+				if name == active {
+					mark = "*"
+				}
+				fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", mark, name, p.Service, p.Index)
+			}
+			return w.Flush()
 		},
 		ValidArgsFunction: cobra.NoFileCompletions,
-	}
+	})
 
-	var copyGet bool
-	var cmdGet = &cobra.Command{
-		Use:   "get <name>",
-		Short: "Get a TOTP code",
-		Long:  "Get a TOTP code from the system keyring.",
-		Args:  cobra.ExactArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			name := args[0]
+	var fromFileRotate, graceRotate string
+	var cancelRotate bool
+	var cmdRotate = &cobra.Command{
+		Use:   "rotate <name>",
+		Short: "Start rotating an entry's secret, keeping the old one until confirmed",
+		Long: `Start rotating the secret of a TOTP entry.
+
+The new secret (typed in, or read with --from-file) is stored as pending next
+to the current one, which stays in use. While the rotation is pending, get
+shows the codes of both ("primary" and "pending"), since the service may
+accept either until the switch is complete. Once the new secret works, run
+"totp confirm-rotation <name>" to make it the entry's secret and discard the
+old one; "totp rotate --cancel <name>" discards the new one instead.
 
-			secret, err := getItem(name)
+After the --grace period (default 1d), get reminds you on stderr to confirm
+the rotation.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cancelRotate && (fromFileRotate != "" || cmd.Flags().Changed("grace")) {
+				return errors.New("--cancel takes no other flags")
+			}
+			name, err := resolveName(args[0])
 			if err != nil {
 				return err
 			}
+			if cancelRotate {
+				if err := cancelRotation(name); err != nil {
+					return err
+				}
+				infof("Cancelled the rotation of \"%v\"; the current secret stays in use.\n", name)
+				return nil
+			}
+			grace, err := parseMaxAge(graceRotate)
+			if err != nil {
+				return fmt.Errorf("invalid --grace: %q (expected e.g. 1d or 12h)", graceRotate)
+			}
 
-			return outputCode(gotp.NewDefaultTOTP(secret).Now(), copyGet)
-		},
-		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
-			if len(args) != 0 {
-				return nil, cobra.ShellCompDirectiveNoFileComp
+			var secret string
+			if fromFileRotate != "" {
+				b, err := os.ReadFile(fromFileRotate)
+				if err != nil {
+					return err
+				}
+				secret = strings.TrimSpace(string(b))
+				wipe(b)
+			} else if secret, err = readLine("Type new secret: "); err != nil {
+				return err
+			}
+			if secret, err = normalizeAndValidateSecret(secret); err != nil {
+				return err
 			}
 
-			names, err := listItems()
+			a, err := startRotation(name, secret, grace)
+			if err != nil {
+				return err
+			}
+			infof("New secret of \"%v\" stored as pending.\n", name)
+			now := accountTime(a, time.Now())
+			code, err := a.code(now)
+			if err != nil {
+				return err
+			}
+			pending, err := a.pendingCode(now)
 			if err != nil {
+				return err
+			}
+			printData("Current code", code)
+			printData("Pending code", pending)
+			infof("Run \"totp confirm-rotation %v\" once the service accepts the new secret.\n", name)
+			return nil
+		},
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
 				return nil, cobra.ShellCompDirectiveNoFileComp
 			}
-
-			return names, cobra.ShellCompDirectiveNoFileComp
+			return completeNames(toComplete), cobra.ShellCompDirectiveNoFileComp
 		},
 	}
+	cmdRotate.Flags().StringVar(&fromFileRotate, "from-file", "", "read the new secret from this file instead of prompting")
+	cmdRotate.Flags().StringVar(&graceRotate, "grace", "1d", "how long to show both codes before reminding you to confirm (e.g. 1d or 12h)")
+	cmdRotate.Flags().BoolVar(&cancelRotate, "cancel", false, "discard the pending secret and keep the current one")
 
-	cmdGet.Flags().BoolVarP(&copyGet, "copy", "c", false, "copy the current code to the clipboard")
-
-	var cmdDelete = &cobra.Command{
-		Use:   "delete <name>",
-		Short: "Delete a TOTP code",
-		Args:  cobra.ExactArgs(1),
+	var cmdConfirmRotation = &cobra.Command{
+		Use:   "confirm-rotation <name>",
+		Short: "Finish rotating an entry's secret, discarding the old one",
+		Long: `Make the pending secret of an entry (see "totp rotate") its secret and
+discard the old one. The secret's age, for --max-age reminders, counts from
+now. "totp undo" can take it back within 5 minutes.`,
+		Args: cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			name := args[0]
-
-			err := deleteItem(name)
+			name, err := resolveName(args[0])
 			if err != nil {
 				return err
 			}
-
-			fmt.Printf("Successfully deleted \"%v\".\n", name)
+			trashed, trashedValues := trashItemsFor([]string{name})
+			a, err := confirmRotation(name)
+			if err != nil {
+				return err
+			}
+			infof("Rotation of \"%v\" confirmed; the old secret is discarded.\n", name)
+			saveTrash(trashRotation, trashed, trashedValues)
+			code, err := a.code(accountTime(a, time.Now()))
+			if err != nil {
+				return err
+			}
+			printData("Current code", code)
 			return nil
 		},
 		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 			if len(args) != 0 {
 				return nil, cobra.ShellCompDirectiveNoFileComp
 			}
-
-			names, err := listItems()
-			if err != nil {
-				return nil, cobra.ShellCompDirectiveNoFileComp
-			}
-
-			return names, cobra.ShellCompDirectiveNoFileComp
+			return completeNames(toComplete), cobra.ShellCompDirectiveNoFileComp
 		},
 	}
 
-	var copyTemp bool
-	var cmdTemp = &cobra.Command{
-		Use:   "temp",
-		Short: "Get a TOTP code from a secret without saving it to the keyring",
-		Args:  cobra.NoArgs,
-		RunE: func(cmd *cobra.Command, args []string) error {
-			var secret string
-			fmt.Print("Type secret: ")
-			fmt.Scanln(&secret)
+	var cmdUndo = &cobra.Command{
+		Use:   "undo",
+		Short: "Undo the last delete, rename or edit",
+		Long: `Undo the last delete, rename or edit, within 5 minutes of it.
 
-			secret, err := normalizeAndValidateSecret(secret)
+Each of those commands keeps what it changed in a trash next to the index,
+replacing whatever was there: secrets are encrypted under a key held in the
+keyring. The trash is wiped once undone, and by any totp command run after
+the 5 minutes are up. Deleted entries whose name has been taken since are
+not restored.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, ok, err := readTrash()
 			if err != nil {
 				return err
 			}
-
-			return outputCode(gotp.NewDefaultTOTP(secret).Now(), copyTemp)
+			if !ok {
+				return fmt.Errorf("Nothing to undo (deletes, renames and edits can be undone for %d minutes)", int(trashTTL.Minutes()))
+			}
+			restored, err := undoTrash(t)
+			for _, name := range restored {
+				infof("Undid the %v of \"%v\".\n", t.Op, name)
+			}
+			return err
 		},
 		ValidArgsFunction: cobra.NoFileCompletions,
 	}
 
-	cmdTemp.Flags().BoolVarP(&copyTemp, "copy", "c", false, "copy the current code to the clipboard")
-
 	var rootCmd = &cobra.Command{Use: "totp", Short: "Simple TOTP CLI, powered by the system keyring", Version: "0.1.1"}
-	rootCmd.AddCommand(cmdScan, cmdAdd, cmdList, cmdGet, cmdDelete, cmdTemp)
+	rootCmd.AddCommand(cmdScan, cmdImportDir, cmdImport, cmdAdd, cmdList, cmdGet, cmdVerify, cmdCopy, cmdNext, cmdSetCounter, cmdDelete, cmdRename, cmdEdit, cmdPrune, cmdValidate, cmdTemp, cmdShowSecret, cmdMigrate, cmdDoctor, cmdStats, cmdURI, cmdQR, cmdExport, cmdBackup, cmdRotate, cmdConfirmRotation, cmdUndo, cmdProfile)
 	rootCmd.CompletionOptions.DisableDefaultCmd = true
+	rootCmd.PersistentFlags().BoolVar(
+		&ignoreCase,
+		"ignore-case",
+		os.Getenv("TOTP_IGNORE_CASE") == "1",
+		"match names case-insensitively (also enabled by TOTP_IGNORE_CASE=1)",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&lenientSecrets,
+		"lenient",
+		false,
+		"also ignore dashes, dots, underscores, tabs and padding in secrets",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&homeOverride,
+		"home",
+		os.Getenv("TOTP_HOME"),
+		"directory to keep the index and file keyring in instead of the home directory (also set by TOTP_HOME)",
+	)
+	rootCmd.PersistentFlags().BoolVarP(
+		&quiet,
+		"quiet",
+		"q",
+		os.Getenv("TOTP_QUIET") == "1",
+		"print only data (codes, names) and errors, no confirmations or notes (also enabled by TOTP_QUIET=1)",
+	)
+	rootCmd.PersistentFlags().BoolVar(
+		&verbose,
+		"verbose",
+		false,
+		"print confirmations even when stdout is not a terminal",
+	)
+	rootCmd.MarkFlagsMutuallyExclusive("quiet", "verbose")
+	rootCmd.PersistentFlags().BoolVar(
+		&readOnly,
+		"read-only",
+		os.Getenv("TOTP_READ_ONLY") == "1",
+		"never write the index, e.g. when the home directory is read-only (also enabled by TOTP_READ_ONLY=1)",
+	)
+	var keyringBackend string
+	rootCmd.PersistentFlags().StringVar(
+		&keyringBackend,
+		"keyring-backend",
+		os.Getenv("TOTP_KEYRING_BACKEND"),
+		"keyring backend: auto (the platform keyring; keychain, secret-service or wincred name it explicitly and fail on other platforms) or file (plaintext, not encrypted) (also set by TOTP_KEYRING_BACKEND)",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&keyringCollection,
+		"keyring-collection",
+		os.Getenv("TOTP_KEYRING_COLLECTION"),
+		"Secret Service collection to keep entries in: a collection label, default, or hardware to detect a hardware-backed one; remembered in the index for later runs (also set by TOTP_KEYRING_COLLECTION)",
+	)
+	rootCmd.PersistentFlags().StringVar(
+		&profileName,
+		"profile",
+		os.Getenv("TOTP_PROFILE"),
+		"profile (keyring service and index) to use, from the configuration file (also set by TOTP_PROFILE)",
+	)
+	rootCmd.RegisterFlagCompletionFunc("profile", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		c, err := readConfig()
+		if err != nil {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		return profileNames(c), cobra.ShellCompDirectiveNoFileComp
+	})
+	rootCmd.PersistentFlags().StringVar(
+		&indexFormat,
+		"index-format",
+		os.Getenv("TOTP_INDEX_FORMAT"),
+		"index file format: json, yaml or toml; default from the index file's extension (also set by TOTP_INDEX_FORMAT)",
+	)
+	rootCmd.RegisterFlagCompletionFunc("index-format", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{indexFormatJSON, indexFormatYAML, indexFormatTOML}, cobra.ShellCompDirectiveNoFileComp
+	})
+	rootCmd.PersistentFlags().StringVar(
+		&indexOrder,
+		"index-order",
+		os.Getenv("TOTP_INDEX_ORDER"),
+		"order of names in the index: name (sorted, the default) or none (as added) (also set by TOTP_INDEX_ORDER)",
+	)
+	rootCmd.RegisterFlagCompletionFunc("index-order", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{indexOrderName, indexOrderNone}, cobra.ShellCompDirectiveNoFileComp
+	})
+	rootCmd.PersistentFlags().StringVar(
+		&colorMode,
+		"color",
+		envOr("TOTP_COLOR", colorAuto),
+		"color output: auto, always or never (also set by TOTP_COLOR; auto honors NO_COLOR)",
+	)
+	rootCmd.RegisterFlagCompletionFunc("color", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		return []string{colorAuto, colorAlways, colorNever}, cobra.ShellCompDirectiveNoFileComp
+	})
+	// selectSettings applies the configuration file to the settings every
+	// command and completion needs. Flags and environment variables win
+	// over the configuration file.
+	selectSettings := func(c config) error {
+		if keyringBackend == "" {
+			keyringBackend = c.KeyringBackend
+		}
+		if keyringCollection == "" {
+			keyringCollection = c.KeyringCollection
+		}
+		if indexFormat == "" {
+			indexFormat = c.IndexFormat
+		}
+		if err := checkIndexFormat(indexFormat); err != nil {
+			return err
+		}
+		if indexOrder == "" {
+			indexOrder = c.IndexOrder
+		}
+		if err := checkIndexOrder(indexOrder); err != nil {
+			return err
+		}
+		if err := selectProfile(c, profileName); err != nil {
+			return err
+		}
+		return selectKeyringBackend(keyringBackend)
+	}
+	prepareCompletion = sync.OnceValue(func() error {
+		readOnly = true
+		c, err := readConfig()
+		if err != nil {
+			return err
+		}
+		return selectSettings(c)
+	})
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if cmd.Name() == cobra.ShellCompRequestCmd || cmd.Name() == cobra.ShellCompNoDescRequestCmd {
+			// Flags are not parsed yet; see prepareCompletion.
+			return nil
+		}
+		c, err := readConfig()
+		if err != nil {
+			return err
+		}
+		if c.Color != "" && !cmd.Flags().Changed("color") && os.Getenv("TOTP_COLOR") == "" {
+			colorMode = c.Color
+		}
+		if err := checkColorMode(colorMode); err != nil {
+			return err
+		}
+		if cmd == cmdAdd {
+			if err := applyConfigDefaults(c, cmd); err != nil {
+				return err
+			}
+		}
+		if err := selectSettings(c); err != nil {
+			return err
+		}
+		expireTrash()
+		return nil
+	}
+	rootCmd.PersistentFlags().IntVar(
+		&keyringRetries,
+		"keyring-retries",
+		keyringRetries,
+		"retry transient keyring errors this many times (also set by TOTP_KEYRING_RETRIES)",
+	)
 	rootCmd.AddCommand(&cobra.Command{
 		Use:   "completion [bash|zsh|fish|powershell]",
 		Short: "Generate shell completion scripts",
-		Args:  cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		Long: `Generate shell completion scripts.
+
+Name completion checks the indexed names against the keyring, which can be
+slow with some keyrings. Set TOTP_FAST_COMPLETION=1 in your shell to complete
+from the index (~/.totp.json) alone; names deleted outside totp may then
+still be offered until the next "totp list".`,
+		Args: cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
 		ValidArgs: []string{
 			"bash",
 			"zsh",
@@ -484,8 +4127,9 @@ func main() {
 			}
 		},
 	})
+	rootCmd.SetArgs(expandAlias(rootCmd, os.Args[1:]))
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		// Cobra has already printed the error to stderr.
+		os.Exit(exitCode(err))
 	}
 }
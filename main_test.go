@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// RFC 6238 test seeds ("1234567890" repeated to the hash's size) in Base32.
+const (
+	seedSHA1   = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	seedSHA256 = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZA"
+	seedSHA512 = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQGEZDGNA"
+)
+
+// useTestKeyring points the file keyring and the index at a fresh directory
+// and stores accounts there by name.
+func useTestKeyring(t *testing.T, accounts map[string]account) {
+	t.Helper()
+	savedStore, savedHome := store, homeOverride
+	t.Cleanup(func() { store, homeOverride = savedStore, savedHome })
+	store, homeOverride = fileKeyring{}, t.TempDir()
+
+	for name, a := range accounts {
+		value, err := encodeAccount(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := keyringSet(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestListCodesMixed checks that list --codes formats each entry with its
+// own parameters when entries with different ones are listed together.
+func TestListCodesMixed(t *testing.T) {
+	withParams := func(secret, algorithm string, digits, period int) account {
+		a := newAccount(secret)
+		a.Algorithm, a.Digits, a.Period = algorithm, digits, period
+		return a
+	}
+	hotp := newAccount(seedSHA1)
+	hotp.Type = accountTypeHOTP
+	locked := newAccount("")
+	locked.Protected = &sealedSecret{}
+
+	useTestKeyring(t, map[string]account{
+		"sha1-6":     withParams(seedSHA1, "SHA1", 6, 30),
+		"sha1-8":     withParams(seedSHA1, "SHA1", 8, 30),
+		"sha256-8":   withParams(seedSHA256, "SHA256", 8, 30),
+		"sha512-8":   withParams(seedSHA512, "SHA512", 8, 30),
+		"sha1-6-p60": withParams(seedSHA1, "SHA1", 6, 60),
+		"hotp":       hotp,
+		"locked":     locked,
+	})
+	names := []string{"sha1-6", "sha1-8", "sha256-8", "sha512-8", "sha1-6-p60", "hotp", "locked"}
+	now := time.Unix(59, 0)
+
+	tests := []struct {
+		name string
+		mask bool
+		want string
+	}{
+		{
+			name: "codes",
+			want: "sha1-6\t287082\n" +
+				"sha1-8\t94287082\n" +
+				"sha256-8\t46119246\n" +
+				"sha512-8\t90693936\n" +
+				"sha1-6-p60\t755224\n" +
+				"hotp\t-\n" +
+				"locked\tlocked\n",
+		},
+		{
+			name: "masked",
+			mask: true,
+			want: "sha1-6\t******\n" +
+				"sha1-8\t********\n" +
+				"sha256-8\t********\n" +
+				"sha512-8\t********\n" +
+				"sha1-6-p60\t******\n" +
+				"hotp\t-\n" +
+				"locked\tlocked\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeListRows(&buf, names, "", false, true, tt.mask, now); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestCurrentCodeMixed checks that get picks the code type and format of
+// each entry from its own stored account.
+func TestCurrentCodeMixed(t *testing.T) {
+	totp := newAccount(seedSHA256)
+	totp.Algorithm, totp.Digits = "SHA256", 8
+	hotp := newAccount(seedSHA1)
+	hotp.Type = accountTypeHOTP
+	useTestKeyring(t, map[string]account{"totp": totp, "hotp": hotp})
+	now := time.Unix(59, 0)
+
+	// RFC 4226 codes for counters 0 and 1: each get uses up one.
+	for _, want := range []string{"755224", "287082"} {
+		info, err := currentCode("hotp", now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Code != want || info.ExpiresIn != 0 {
+			t.Errorf("hotp: got %v (%vs), want %v (0s)", info.Code, info.ExpiresIn, want)
+		}
+	}
+
+	info, err := currentCode("totp", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Code != "46119246" || info.ExpiresIn != 1 || info.Period != 30 {
+		t.Errorf("totp: got %v (%vs, period %v), want 46119246 (1s, period 30)", info.Code, info.ExpiresIn, info.Period)
+	}
+}
+
+func TestSaveScanImageStaysInDir(t *testing.T) {
+	tests := []struct {
+		name, src, want string
+	}{
+		{"github", "/tmp/github.png", "github/github.png"},
+		{"work/vpn", "/tmp/vpn.png", "work_vpn/vpn.png"},
+		{"..", "/tmp/qr.png", "_../qr.png"},
+		{".", "/tmp/qr.png", "_./qr.png"},
+		{"github", "https://example.com/..", "github/qr"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if err := saveScanImage(dir, tt.name, tt.src, []byte("image")); err != nil {
+			t.Errorf("saveScanImage(%q, %q): %v", tt.name, tt.src, err)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(tt.want))); err != nil {
+			t.Errorf("saveScanImage(%q, %q) did not write %v: %v", tt.name, tt.src, tt.want, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseMaxAge parses a rotation age such as "90d" or "720h". Besides the
+// units time.ParseDuration understands, it accepts whole days with "d".
+func parseMaxAge(s string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid max age: %q (expected e.g. 90d or 720h)", s)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid max age: %q (expected e.g. 90d or 720h)", s)
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("invalid max age: %q (expected a positive duration)", s)
+	}
+	return d, nil
+}
+
+// formatAge renders d in whole days once it reaches a day, and as a plain
+// duration below that.
+func formatAge(d time.Duration) string {
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.Round(time.Second).String()
+}
+
+// rotationDue reports whether the entry's secret has outlived its
+// rotate_after age, counted from its last rotation or else its creation,
+// returning its age.
+func rotationDue(e indexEntry, now time.Time) (time.Duration, bool) {
+	if e.RotateAfter <= 0 || e.Created == 0 {
+		return 0, false
+	}
+	age := now.Sub(time.Unix(max(e.Created, e.Rotated), 0))
+	return age, age > time.Duration(e.RotateAfter)*time.Second
+}
+
+// warnRotation prints a reminder to stderr for each of names whose secret is
+// older than its max age. It is advisory only, and silent with --quiet.
+func warnRotation(names []string, idx indexFile, now time.Time) {
+	if quiet {
+		return
+	}
+	for _, name := range names {
+		e := idx.Entries[name]
+		if age, due := rotationDue(e, now); due {
+			fmt.Fprintf(os.Stderr, "Reminder: the secret of \"%v\" is %v old (max age %v); consider rotating it.\n",
+				name, formatAge(age), formatAge(time.Duration(e.RotateAfter)*time.Second))
+		}
+	}
+}
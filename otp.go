@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+const (
+	defaultDigits    = 6
+	defaultPeriod    = 30
+	defaultAlgorithm = "SHA1"
+)
+
+// decodeSecret decodes a normalized Base32 secret into a fresh key buffer.
+// Callers own the buffer and should wipe it once the code is generated.
+func decodeSecret(secret string) ([]byte, error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	trimmed := strings.TrimRight(secret, "=")
+	key := make([]byte, enc.DecodedLen(len(trimmed)))
+	n, err := enc.Decode(key, []byte(trimmed))
+	if err != nil {
+		wipe(key)
+		return nil, err
+	}
+	return key[:n], nil
+}
+
+// wipe zeroes b so the key material does not linger on the heap until the
+// garbage collector gets to it.
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// hashFunc returns the HMAC hash for an otpauth algorithm name.
+func hashFunc(algorithm string) (func() hash.Hash, error) {
+	switch strings.ToUpper(algorithm) {
+	case "", "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm: %q", algorithm)
+	}
+}
+
+// algorithmNames are the HMAC algorithms RFC 6238 defines, as given on the
+// command line.
+var algorithmNames = []string{"sha1", "sha256", "sha512"}
+
+// likelyAlgorithm guesses the algorithm of a key from its length: RFC 6238's
+// reference keys are as long as the hash output, and services that use
+// SHA-256 or SHA-512 mostly follow suit. Anything else is most likely SHA-1.
+func likelyAlgorithm(keyLen int) string {
+	switch keyLen {
+	case sha256.Size:
+		return "sha256"
+	case sha512.Size:
+		return "sha512"
+	default:
+		return "sha1"
+	}
+}
+
+// hotpCode computes the RFC 4226 code for key and counter.
+func hotpCode(key []byte, counter uint64, digits int, h func() hash.Hash) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+
+	mac := hmac.New(h, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	defer wipe(sum)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := uint64(binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff)
+
+	mod := uint64(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// errNotOTP is returned by parseOTPAuthURL for URIs that are not
+// otpauth://totp/ or otpauth://hotp/ key URIs.
+var errNotOTP = errors.New("Given QR code is not for TOTP or HOTP")
+
+// allowRawSecrets makes parseScannedText accept QR codes that hold a bare
+// Base32 secret instead of an otpauth URI (scan and import-dir
+// --allow-raw-secret).
+var allowRawSecrets bool
+
+// minRawSecretLen is the length below which QR code text is not taken for a
+// bare secret: 16 Base32 characters are 80 bits, the shortest secret in
+// common use. Plenty of short words are valid Base32.
+const minRawSecretLen = 16
+
+// parseScannedText parses the text of a scanned QR code. It is normally an
+// otpauth URI; with allowRawSecrets, a bare Base32 secret is accepted too and
+// registered with the default parameters, with a warning since nothing in the
+// code confirms them.
+func parseScannedText(text string) (account, error) {
+	a, err := parseOTPAuthURL(text)
+	if !errors.Is(err, errNotOTP) {
+		return a, err
+	}
+	secret, serr := normalizeAndValidateSecret(text)
+	if serr != nil || len(secret) < minRawSecretLen {
+		return account{}, err
+	}
+	if !allowRawSecrets {
+		return account{}, fmt.Errorf("%w; it holds what looks like a bare secret, which --allow-raw-secret accepts", err)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: the QR code holds a bare secret, not an otpauth URI; assuming %v, %d digits, %ds period.\n", defaultAlgorithm, defaultDigits, defaultPeriod)
+	return newAccount(secret), nil
+}
+
+// parseOTPAuthURL parses an otpauth://totp/ or otpauth://hotp/ key URI, as
+// found in QR codes, into an account. Missing parameters fall back to the
+// defaults; HOTP URIs without a counter start at 0.
+//
+// See https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func parseOTPAuthURL(text string) (account, error) {
+	parsed, err := url.Parse(text)
+	if err != nil {
+		return account{}, err
+	}
+	if parsed.Scheme != "otpauth" || (parsed.Host != accountTypeTOTP && parsed.Host != accountTypeHOTP) {
+		return account{}, errNotOTP
+	}
+
+	query, err := url.ParseQuery(parsed.RawQuery)
+	if err != nil {
+		return account{}, fmt.Errorf("invalid otpauth URI parameters: %w", err)
+	}
+	secret := query.Get("secret")
+	if strings.Contains(secret, "%") {
+		// Some providers percent-encode the secret twice.
+		if unescaped, err := url.QueryUnescape(secret); err == nil {
+			secret = unescaped
+		}
+	}
+	// The key URI format omits Base32 padding, but not every provider does.
+	secret, err = normalizeAndValidateSecret(strings.TrimRight(secret, "="))
+	if err != nil {
+		return account{}, err
+	}
+	a := newAccount(secret)
+	if parsed.Host == accountTypeHOTP {
+		a.Type = accountTypeHOTP
+	}
+
+	// The label is "Issuer:Account" or just "Account".
+	label := strings.TrimPrefix(parsed.Path, "/")
+	if issuer, name, ok := strings.Cut(label, ":"); ok {
+		a.Issuer = strings.TrimSpace(issuer)
+		a.Account = strings.TrimSpace(name)
+	} else {
+		a.Account = strings.TrimSpace(label)
+	}
+	if issuer := query.Get("issuer"); issuer != "" {
+		a.Issuer = issuer
+	}
+
+	if algorithm := query.Get("algorithm"); algorithm != "" {
+		a.Algorithm = strings.ToUpper(algorithm)
+	}
+	if digits := query.Get("digits"); digits != "" {
+		n, err := strconv.Atoi(digits)
+		if err != nil {
+			return account{}, fmt.Errorf("invalid digits: %q", digits)
+		}
+		a.Digits = n
+	}
+	if period := query.Get("period"); period != "" {
+		n, err := strconv.Atoi(period)
+		if err != nil {
+			return account{}, fmt.Errorf("invalid period: %q", period)
+		}
+		a.Period = n
+	}
+	if counter := query.Get("counter"); counter != "" && a.Type == accountTypeHOTP {
+		n, err := strconv.ParseUint(counter, 10, 64)
+		if err != nil {
+			return account{}, fmt.Errorf("invalid counter: %q", counter)
+		}
+		a.Counter = n
+	}
+	if err := checkParams(a.Algorithm, a.Digits, a.Period); err != nil {
+		return account{}, err
+	}
+	return a, nil
+}
+
+const (
+	labelFormatIssuerAccount = "issuer-account"
+	labelFormatAccount       = "account"
+)
+
+// escapeURIComponent percent-encodes s for use in an otpauth label or query
+// value. Unlike url.QueryEscape it encodes spaces as %20, which every
+// authenticator app understands, rather than "+", which some show literally.
+func escapeURIComponent(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// buildOTPAuthURL builds the otpauth key URI for the account stored under
+// name. The label is "Issuer:Account" (labelFormatIssuerAccount) or just
+// "Account" (labelFormatAccount); the issuer parameter is always included
+// when known. The account label falls back to name.
+func buildOTPAuthURL(name string, a account, labelFormat string) (string, error) {
+	accountLabel := a.Account
+	if accountLabel == "" {
+		accountLabel = name
+	}
+
+	var label string
+	switch labelFormat {
+	case labelFormatIssuerAccount:
+		label = escapeURIComponent(accountLabel)
+		if a.Issuer != "" {
+			label = escapeURIComponent(a.Issuer) + ":" + label
+		}
+	case labelFormatAccount:
+		label = escapeURIComponent(accountLabel)
+	default:
+		return "", fmt.Errorf("unknown label format %q (expected %v or %v)", labelFormat, labelFormatIssuerAccount, labelFormatAccount)
+	}
+
+	typ := accountTypeTOTP
+	if a.Type == accountTypeHOTP {
+		typ = accountTypeHOTP
+	}
+
+	params := []string{"secret=" + a.Secret}
+	if a.Issuer != "" {
+		params = append(params, "issuer="+escapeURIComponent(a.Issuer))
+	}
+	params = append(params,
+		"algorithm="+strings.ToUpper(a.Algorithm),
+		"digits="+strconv.Itoa(a.Digits),
+	)
+	if typ == accountTypeHOTP {
+		params = append(params, "counter="+strconv.FormatUint(a.Counter, 10))
+	} else {
+		params = append(params, "period="+strconv.Itoa(a.Period))
+	}
+
+	return "otpauth://" + typ + "/" + label + "?" + strings.Join(params, "&"), nil
+}
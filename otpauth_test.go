@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseOTPAuthURL(t *testing.T) {
+	totp := func(secret, issuer, name string) account {
+		a := newAccount(secret)
+		a.Issuer, a.Account = issuer, name
+		return a
+	}
+
+	tests := []struct {
+		name    string
+		uri     string
+		want    account
+		wantErr error  // matched with errors.Is when set
+		errText string // otherwise matched as a substring
+	}{
+		{
+			name: "plain",
+			uri:  "otpauth://totp/GitHub:alice?secret=JBSWY3DPEHPK3PXP&issuer=GitHub",
+			want: totp("JBSWY3DPEHPK3PXP", "GitHub", "alice"),
+		},
+		{
+			name: "double-encoded secret",
+			uri:  "otpauth://totp/alice?secret=JBSW%2520Y3DP%2520EHPK%25203PXP",
+			want: totp("JBSWY3DPEHPK3PXP", "", "alice"),
+		},
+		{
+			name: "padded secret",
+			uri:  "otpauth://totp/alice?secret=MFRGG===",
+			want: totp("MFRGG", "", "alice"),
+		},
+		{
+			name: "percent-encoded padding",
+			uri:  "otpauth://totp/alice?secret=MFRGG%3D%3D%3D",
+			want: totp("MFRGG", "", "alice"),
+		},
+		{
+			name:    "literal percent that does not decode",
+			uri:     "otpauth://totp/alice?secret=JBSW%25Y3DP",
+			wantErr: ErrInvalidSecret,
+		},
+		{
+			name:    "malformed query",
+			uri:     "otpauth://totp/alice?secret=JBSW%ZZ",
+			errText: "invalid otpauth URI parameters",
+		},
+		{
+			name:    "missing secret",
+			uri:     "otpauth://totp/alice?issuer=GitHub",
+			wantErr: ErrInvalidSecret,
+		},
+		{
+			name:    "not otpauth",
+			uri:     "https://example.com/?secret=JBSWY3DPEHPK3PXP",
+			wantErr: errNotOTP,
+		},
+		{
+			name: "hotp with parameters",
+			uri:  "otpauth://hotp/Corp:vpn?secret=JBSWY3DPEHPK3PXP&algorithm=sha256&digits=8&counter=42",
+			want: func() account {
+				a := totp("JBSWY3DPEHPK3PXP", "Corp", "vpn")
+				a.Type, a.Algorithm, a.Digits, a.Counter = accountTypeHOTP, "SHA256", 8, 42
+				return a
+			}(),
+		},
+		{
+			name:    "invalid digits",
+			uri:     "otpauth://totp/alice?secret=JBSWY3DPEHPK3PXP&digits=six",
+			errText: `invalid digits: "six"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseOTPAuthURL(tt.uri)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got error %v, want %v", err, tt.wantErr)
+				}
+			case tt.errText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.errText) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.errText)
+				}
+			case err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case !reflect.DeepEqual(got, tt.want):
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const defaultProfileName = "default"
+
+// profile is a named pair of keyring service and index file, so that
+// separate sets of entries (say, work and personal) never mix. Empty fields
+// default to "totp-<name>" and ~/.totp-<name>.json (or the extension of
+// --index-format).
+type profile struct {
+	Service string `json:"service,omitempty"`
+	Index   string `json:"index,omitempty"` // absolute, or relative to the home directory
+}
+
+// profileIndexPath is the index file of the selected profile; empty means
+// the default ~/.totp.json.
+var profileIndexPath string
+
+// profileNames returns the default profile followed by the configured ones,
+// sorted.
+func profileNames(c config) []string {
+	names := []string{defaultProfileName}
+	var configured []string
+	for name := range c.Profiles {
+		if name != defaultProfileName {
+			configured = append(configured, name)
+		}
+	}
+	sort.Strings(configured)
+	return append(names, configured...)
+}
+
+// resolveProfile fills in the defaults of the profile called name and makes
+// its index path absolute.
+func resolveProfile(c config, name string) (profile, error) {
+	home, err := homeDir()
+	if err != nil {
+		return profile{}, err
+	}
+
+	p, ok := c.Profiles[name]
+	if !ok && name != defaultProfileName {
+		return profile{}, fmt.Errorf("unknown profile %q (available: %v)", name, strings.Join(profileNames(c), ", "))
+	}
+
+	if p.Service == "" {
+		p.Service = "totp"
+		if name == defaultProfileName && c.Service != "" {
+			p.Service = c.Service
+		} else if name != defaultProfileName {
+			p.Service = "totp-" + name
+		}
+	}
+	if p.Index == "" {
+		p.Index = ".totp" + indexExt()
+		if name != defaultProfileName {
+			p.Index = ".totp-" + name + indexExt()
+		}
+	}
+	if rest, ok := strings.CutPrefix(p.Index, "~/"); ok {
+		p.Index = rest
+	}
+	if !filepath.IsAbs(p.Index) {
+		p.Index = filepath.Join(home, p.Index)
+	}
+	return p, nil
+}
+
+// selectProfile switches the keyring service and index file to those of the
+// named profile in c. Unless configured otherwise, the default profile keeps
+// the historical locations, or just the configured default service.
+func selectProfile(c config, name string) error {
+	if name == "" {
+		name = defaultProfileName
+	}
+
+	if _, ok := c.Profiles[name]; !ok && name == defaultProfileName {
+		if c.Service != "" {
+			serviceName = c.Service
+		}
+		return nil
+	}
+	p, err := resolveProfile(c, name)
+	if err != nil {
+		return err
+	}
+	serviceName = p.Service
+	profileIndexPath = p.Index
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+// scrypt parameters for deriving the key that seals protected secrets. They
+// are stored with each sealed secret so they can be raised later.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// sealedSecret is a secret encrypted with AES-256-GCM under a key derived
+// from a user passphrase with scrypt.
+type sealedSecret struct {
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+var errWrongPassphrase = errors.New("Wrong passphrase")
+
+func sealCipher(passphrase, salt []byte, n, r, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, n, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+	defer wipe(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// sealSecret encrypts secret under passphrase.
+func sealSecret(secret string, passphrase []byte) (*sealedSecret, error) {
+	s := &sealedSecret{N: scryptN, R: scryptR, P: scryptP, Salt: make([]byte, 16)}
+	if _, err := rand.Read(s.Salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := sealCipher(passphrase, s.Salt, s.N, s.R, s.P)
+	if err != nil {
+		return nil, err
+	}
+	s.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(s.Nonce); err != nil {
+		return nil, err
+	}
+
+	plaintext := []byte(secret)
+	defer wipe(plaintext)
+	s.Ciphertext = aead.Seal(nil, s.Nonce, plaintext, nil)
+	return s, nil
+}
+
+// open decrypts the sealed secret with passphrase.
+func (s *sealedSecret) open(passphrase []byte) (string, error) {
+	aead, err := sealCipher(passphrase, s.Salt, s.N, s.R, s.P)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := aead.Open(nil, s.Nonce, s.Ciphertext, nil)
+	if err != nil {
+		return "", errWrongPassphrase
+	}
+	defer wipe(plaintext)
+	return string(plaintext), nil
+}
+
+// readPassphrase prompts on stderr and reads a passphrase without echo when
+// stdin is a terminal, or a plain line otherwise.
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		b, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return b, err
+	}
+
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
+
+// readNewPassphrase asks for a new passphrase twice and checks they match.
+func readNewPassphrase() ([]byte, error) {
+	first, err := readPassphrase("New passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if len(first) == 0 {
+		return nil, errors.New("Empty passphrase")
+	}
+
+	second, err := readPassphrase("Repeat passphrase: ")
+	if err != nil {
+		wipe(first)
+		return nil, err
+	}
+	defer wipe(second)
+
+	if string(first) != string(second) {
+		wipe(first)
+		return nil, errors.New("Passphrases do not match")
+	}
+	return first, nil
+}
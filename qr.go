@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+	"github.com/makiuchi-d/gozxing/common"
+	"github.com/makiuchi-d/gozxing/qrcode"
+)
+
+// qrQuietZone is the light border, in modules, drawn around terminal QR
+// codes. The spec asks for 4, but 2 scans reliably and saves screen space.
+const qrQuietZone = 2
+
+// encodeQR encodes text as a QR code with one matrix cell per module.
+func encodeQR(text string) (*gozxing.BitMatrix, error) {
+	hints := map[gozxing.EncodeHintType]interface{}{
+		gozxing.EncodeHintType_MARGIN: qrQuietZone,
+	}
+	return qrcode.NewQRCodeWriter().Encode(text, gozxing.BarcodeFormat_QR_CODE, 0, 0, hints)
+}
+
+const (
+	qrFormatUTF8  = "qr-utf8"
+	qrFormatASCII = "qr-ascii"
+)
+
+// defaultQRFormat picks qrFormatUTF8 when the locale (or, on Windows, the
+// terminal) is known to handle Unicode, and qrFormatASCII otherwise.
+func defaultQRFormat() string {
+	for _, env := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if v := strings.ToLower(os.Getenv(env)); v != "" {
+			if strings.Contains(v, "utf-8") || strings.Contains(v, "utf8") {
+				return qrFormatUTF8
+			}
+			return qrFormatASCII
+		}
+	}
+	if runtime.GOOS == "windows" && os.Getenv("WT_SESSION") != "" {
+		return qrFormatUTF8
+	}
+	return qrFormatASCII
+}
+
+// decodeHints maps the names accepted by `scan --hint` to the gozxing decode
+// hints the QR reader honors. Boolean hints take effect by being present.
+var decodeHints = map[string]gozxing.DecodeHintType{
+	"pure_barcode":  gozxing.DecodeHintType_PURE_BARCODE,
+	"try_harder":    gozxing.DecodeHintType_TRY_HARDER,
+	"character_set": gozxing.DecodeHintType_CHARACTER_SET,
+}
+
+// parseDecodeHints builds a gozxing hint map from key=value specs. Keys are
+// case-insensitive and may use dashes; boolean hints accept a bare key, true
+// or false, and character_set must name a character set gozxing knows.
+func parseDecodeHints(specs []string) (map[gozxing.DecodeHintType]interface{}, error) {
+	hints := map[gozxing.DecodeHintType]interface{}{}
+	for _, spec := range specs {
+		key, value, hasValue := strings.Cut(spec, "=")
+		key = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "-", "_")
+		hint, ok := decodeHints[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown decode hint %q (expected pure_barcode, try_harder or character_set)", key)
+		}
+
+		if hint == gozxing.DecodeHintType_CHARACTER_SET {
+			if _, ok := common.GetCharacterSetECIByName(value); !ok {
+				return nil, fmt.Errorf("unknown character set %q for decode hint character_set (e.g. UTF-8, ISO-8859-1, Shift_JIS)", value)
+			}
+			hints[hint] = value
+			continue
+		}
+
+		on := true
+		if hasValue {
+			var err error
+			if on, err = strconv.ParseBool(value); err != nil {
+				return nil, fmt.Errorf("invalid value %q for decode hint %v (expected true or false)", value, key)
+			}
+		}
+		if on {
+			hints[hint] = true
+		} else {
+			delete(hints, hint)
+		}
+	}
+	return hints, nil
+}
+
+// noQRFallback stops decodeQRImage from retrying images it cannot read with
+// more exhaustive settings (--no-fallback), for speed.
+var noQRFallback bool
+
+// decodeQRImage reads the QR code in img with the given gozxing hints (see
+// parseDecodeHints); PURE_BARCODE helps with images that contain nothing but
+// the code.
+//
+// If that fails and noQRFallback is not set, it tries again in tiers, each slower
+// than the last: with TRY_HARDER, then also with PURE_BARCODE, then with the
+// image inverted, for light-on-dark codes such as dark mode screenshots.
+// The first error is returned if every tier fails.
+func decodeQRImage(img image.Image, hints map[gozxing.DecodeHintType]interface{}) (string, error) {
+	source := gozxing.NewLuminanceSourceFromImage(img)
+	text, firstErr := decodeQRSource(source, hints)
+	if firstErr == nil || noQRFallback {
+		return text, firstErr
+	}
+
+	harder := withDecodeHint(hints, gozxing.DecodeHintType_TRY_HARDER)
+	tiers := []struct {
+		source gozxing.LuminanceSource
+		hints  map[gozxing.DecodeHintType]interface{}
+	}{
+		{source, harder},
+		{source, withDecodeHint(harder, gozxing.DecodeHintType_PURE_BARCODE)},
+		{gozxing.NewInvertedLuminanceSource(source), harder},
+	}
+	for _, tier := range tiers {
+		if tier.source == source && len(tier.hints) == len(hints) {
+			continue // the hints were already given
+		}
+		if text, err := decodeQRSource(tier.source, tier.hints); err == nil {
+			return text, nil
+		}
+	}
+	return "", firstErr
+}
+
+// decodeQRSource reads the QR code in source with the given hints.
+func decodeQRSource(source gozxing.LuminanceSource, hints map[gozxing.DecodeHintType]interface{}) (string, error) {
+	bmp, err := gozxing.NewBinaryBitmap(gozxing.NewHybridBinarizer(source))
+	if err != nil {
+		return "", err
+	}
+
+	result, err := qrcode.NewQRCodeReader().Decode(bmp, hints)
+	if err != nil {
+		return "", err
+	}
+	return result.GetText(), nil
+}
+
+// withDecodeHint returns a copy of hints with the boolean hint set.
+func withDecodeHint(hints map[gozxing.DecodeHintType]interface{}, hint gozxing.DecodeHintType) map[gozxing.DecodeHintType]interface{} {
+	out := make(map[gozxing.DecodeHintType]interface{}, len(hints)+1)
+	for k, v := range hints {
+		out[k] = v
+	}
+	out[hint] = true
+	return out
+}
+
+// decodeQRFrames is decodeQRImage for animated GIFs: it tries every frame,
+// composited onto the canvas as a viewer would show it, and returns the
+// first QR code found. Other image formats are decoded as a single frame.
+func decodeQRFrames(r io.Reader, hints map[gozxing.DecodeHintType]interface{}) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		return decodeQRImage(img, hints)
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, anim.Config.Width, anim.Config.Height))
+	var lastErr error
+	for _, frame := range anim.Image {
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		text, err := decodeQRImage(canvas, hints)
+		if err == nil {
+			return text, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = errors.New("GIF has no frames")
+	}
+	return "", fmt.Errorf("no frame contains a QR code: %w", lastErr)
+}
+
+// renderQR writes text as a QR code in the given format. Light modules are
+// drawn filled, so the code reads correctly on the usual light-on-dark
+// terminal.
+func renderQR(w io.Writer, text, format string) error {
+	m, err := encodeQR(text)
+	if err != nil {
+		return err
+	}
+
+	var out string
+	switch format {
+	case qrFormatUTF8:
+		out = qrUTF8(m)
+	case qrFormatASCII:
+		out = qrASCII(m)
+	default:
+		return fmt.Errorf("unknown output format %q (expected %v or %v)", format, qrFormatUTF8, qrFormatASCII)
+	}
+	_, err = fmt.Fprint(w, out)
+	return err
+}
+
+// qrUTF8 draws m with Unicode half blocks, packing two module rows into each
+// line.
+func qrUTF8(m *gozxing.BitMatrix) string {
+
+	light := func(x, y int) bool {
+		return y >= m.GetHeight() || !m.Get(x, y)
+	}
+
+	var b strings.Builder
+	for y := 0; y < m.GetHeight(); y += 2 {
+		for x := 0; x < m.GetWidth(); x++ {
+			top, bottom := light(x, y), light(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// qrASCII draws m with two "#" characters per light module, one line per
+// module row, for terminals without block characters.
+func qrASCII(m *gozxing.BitMatrix) string {
+	var b strings.Builder
+	for y := 0; y < m.GetHeight(); y++ {
+		for x := 0; x < m.GetWidth(); x++ {
+			if m.Get(x, y) {
+				b.WriteString("  ")
+			} else {
+				b.WriteString("##")
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// renamePair is one planned rename from From to To.
+type renamePair struct {
+	From, To string
+}
+
+// planRegexRename returns a rename for every indexed name that re matches
+// and whose replacement differs from the name, sorted by the old name.
+func planRegexRename(re *regexp.Regexp, replacement string) ([]renamePair, error) {
+	idx, err := readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var pairs []renamePair
+	for _, name := range idx.Names {
+		if !re.MatchString(name) {
+			continue
+		}
+		if to := re.ReplaceAllString(name, replacement); to != name {
+			pairs = append(pairs, renamePair{From: name, To: to})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].From < pairs[j].From })
+	return pairs, nil
+}
+
+// checkRenames rejects plans with empty targets, two sources sharing one
+// target, or a target that already exists. Chained renames (a to b while b
+// is renamed to c) count as collisions too, which keeps the move simple.
+func checkRenames(pairs []renamePair) error {
+	targets := map[string]string{}
+	for _, p := range pairs {
+		if strings.TrimSpace(p.To) == "" {
+			return fmt.Errorf("Renaming \"%v\" would leave an empty name", p.From)
+		}
+		if strings.EqualFold(p.From, p.To) {
+			// Some keyrings (e.g. Windows Credential Manager) ignore case,
+			// so writing the new name would overwrite the old one.
+			return fmt.Errorf("Renaming \"%v\" to \"%v\" only changes the case, which is not supported", p.From, p.To)
+		}
+
+		key := p.To
+		if ignoreCase {
+			key = strings.ToLower(key)
+		}
+		if prev, ok := targets[key]; ok {
+			return fmt.Errorf("Both \"%v\" and \"%v\" would be renamed to \"%v\"", prev, p.From, p.To)
+		}
+		targets[key] = p.From
+
+		exists, err := nameExists(p.To)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("%w: \"%v\"", ErrNameExists, p.To)
+		}
+	}
+	return nil
+}
+
+// renameItems moves each keyring entry in pairs to its new name and updates
+// the index, all under the index lock. Every new entry is written before any
+// old one is deleted; if anything fails, the entries already touched are put
+// back so the keyring and index are left as they were.
+func renameItems(pairs []renamePair) error {
+	return withIndexLock(func() error {
+		if err := checkRenames(pairs); err != nil {
+			return err
+		}
+
+		values := make([]string, len(pairs))
+		for i, p := range pairs {
+			value, err := keyringGet(p.From)
+			if err != nil {
+				return fmt.Errorf("reading \"%v\": %w", p.From, err)
+			}
+			values[i] = value
+		}
+
+		var written []string
+		rollback := func() error {
+			var errs []error
+			for _, name := range written {
+				if err := keyringDelete(name); err != nil {
+					errs = append(errs, fmt.Errorf("removing \"%v\": %w", name, err))
+				}
+			}
+			return errors.Join(errs...)
+		}
+
+		for i, p := range pairs {
+			if err := keyringSet(p.To, values[i]); err != nil {
+				return errors.Join(fmt.Errorf("writing \"%v\": %w", p.To, err), rollback())
+			}
+			written = append(written, p.To)
+		}
+
+		for i, p := range pairs {
+			if err := keyringDelete(p.From); err != nil {
+				errs := []error{fmt.Errorf("removing \"%v\": %w", p.From, err)}
+				for j := range pairs[:i] {
+					if err := keyringSet(pairs[j].From, values[j]); err != nil {
+						errs = append(errs, fmt.Errorf("restoring \"%v\": %w", pairs[j].From, err))
+					}
+				}
+				errs = append(errs, rollback())
+				return errors.Join(errs...)
+			}
+		}
+
+		idx, err := readIndex()
+		if err != nil {
+			return err
+		}
+		for _, p := range pairs {
+			found := false
+			for i, name := range idx.Names {
+				if name == p.From {
+					idx.Names[i] = p.To
+					found = true
+				}
+			}
+			if !found {
+				idx.Names = append(idx.Names, p.To)
+			}
+			if idx.Entries == nil {
+				idx.Entries = map[string]indexEntry{}
+			}
+			entry := idx.Entries[p.From]
+			delete(idx.Entries, p.From)
+			entry.Modified = time.Now().Unix()
+			idx.Entries[p.To] = entry
+		}
+		return writeIndex(idx)
+	})
+}
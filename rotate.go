@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// pendingRotation is a new secret waiting for `totp confirm-rotation`. Until
+// then the service may accept codes of either secret, so both are shown.
+type pendingRotation struct {
+	Secret string `json:"secret"`
+	Since  int64  `json:"since"` // Unix time the rotation was started
+	Grace  int64  `json:"grace"` // seconds after Since before get reminds you to confirm
+}
+
+// overdue reports whether the rotation has outlived its grace period.
+func (p *pendingRotation) overdue(now time.Time) bool {
+	return now.After(time.Unix(p.Since+p.Grace, 0))
+}
+
+// pendingCode returns the code of the pending secret at t, or "" without a
+// rotation in progress.
+func (a account) pendingCode(t time.Time) (string, error) {
+	if a.Pending == nil {
+		return "", nil
+	}
+	b := a
+	b.Secret = a.Pending.Secret
+	return b.code(t)
+}
+
+// startRotation stores secret as the pending secret of the entry name,
+// leaving the current one in place.
+func startRotation(name, secret string, grace time.Duration) (account, error) {
+	a, err := getItem(name)
+	if err != nil {
+		return account{}, err
+	}
+	switch {
+	case a.Type == accountTypeHOTP:
+		return account{}, errors.New("Rotation is only supported for TOTP entries")
+	case a.Protected != nil:
+		return account{}, errors.New("Protected entries cannot be rotated; store the new secret with \"totp add\" instead")
+	case a.Pending != nil:
+		return account{}, fmt.Errorf("A rotation of \"%v\" is already pending; confirm it with \"totp confirm-rotation\" or cancel it with \"totp rotate --cancel\"", name)
+	case secret == a.Secret:
+		return account{}, errors.New("The new secret is the same as the current one")
+	}
+	a.Pending = &pendingRotation{Secret: secret, Since: time.Now().Unix(), Grace: int64(grace / time.Second)}
+	return a, addItem(name, a)
+}
+
+// cancelRotation discards the pending secret of the entry name.
+func cancelRotation(name string) error {
+	a, err := getItem(name)
+	if err != nil {
+		return err
+	}
+	if a.Pending == nil {
+		return fmt.Errorf("No rotation of \"%v\" is pending", name)
+	}
+	a.Pending = nil
+	return addItem(name, a)
+}
+
+// confirmRotation makes the pending secret of the entry name its secret,
+// discarding the old one, and records the rotation in the index so that the
+// secret's age counts from now.
+func confirmRotation(name string) (account, error) {
+	a, err := getItem(name)
+	if err != nil {
+		return account{}, err
+	}
+	if a.Pending == nil {
+		return account{}, fmt.Errorf("No rotation of \"%v\" is pending; start one with \"totp rotate\"", name)
+	}
+	a.Secret = a.Pending.Secret
+	a.Pending = nil
+	if err := addItem(name, a); err != nil {
+		return account{}, err
+	}
+	if err := updateIndex(func(idx *indexFile) error {
+		if idx.Entries == nil {
+			idx.Entries = map[string]indexEntry{}
+		}
+		entry := idx.Entries[name]
+		entry.Rotated = time.Now().Unix()
+		idx.Entries[name] = entry
+		return nil
+	}); err != nil {
+		return account{}, err
+	}
+	return a, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/makiuchi-d/gozxing"
+)
+
+// maxDisplays is how many displays macOS screencapture is asked to capture,
+// one file each; files for displays that do not exist are not written.
+const maxDisplays = 8
+
+// screenshotTool is a Linux screenshot command and how to ask it for the
+// whole screen, or a region the user selects, in file.
+type screenshotTool struct {
+	name    string
+	wayland bool // only works under Wayland
+	args    func(file string, region bool) ([]string, error)
+}
+
+var linuxScreenshotTools = []screenshotTool{
+	{"grim", true, func(file string, region bool) ([]string, error) {
+		if !region {
+			return []string{file}, nil
+		}
+		// grim cannot select a region itself; slurp does.
+		out, err := exec.Command("slurp").Output()
+		if err != nil {
+			return nil, fmt.Errorf("slurp: %w (is it installed?)", err)
+		}
+		return []string{"-g", strings.TrimSpace(string(out)), file}, nil
+	}},
+	{"gnome-screenshot", false, func(file string, region bool) ([]string, error) {
+		if region {
+			return []string{"-a", "-f", file}, nil
+		}
+		return []string{"-f", file}, nil
+	}},
+	{"spectacle", false, func(file string, region bool) ([]string, error) {
+		if region {
+			return []string{"-b", "-n", "-r", "-o", file}, nil
+		}
+		return []string{"-b", "-n", "-f", "-o", file}, nil
+	}},
+	{"scrot", false, func(file string, region bool) ([]string, error) {
+		if region {
+			return []string{"-s", file}, nil
+		}
+		return []string{file}, nil
+	}},
+	{"import", false, func(file string, region bool) ([]string, error) {
+		if region {
+			return []string{file}, nil
+		}
+		return []string{"-window", "root", file}, nil
+	}},
+}
+
+// windowsScreenshotScript saves the whole virtual screen, which spans every
+// monitor, to the PNG file given as its argument.
+const windowsScreenshotScript = `Add-Type -AssemblyName System.Windows.Forms,System.Drawing
+$b = [System.Windows.Forms.SystemInformation]::VirtualScreen
+$bmp = New-Object System.Drawing.Bitmap $b.Width, $b.Height
+$g = [System.Drawing.Graphics]::FromImage($bmp)
+$g.CopyFromScreen($b.Left, $b.Top, 0, 0, $bmp.Size)
+$bmp.Save($args[0], [System.Drawing.Imaging.ImageFormat]::Png)`
+
+// captureScreen takes screenshots into dir with the platform's screenshot
+// tool and returns the image files written: one per display on macOS, one
+// spanning every display elsewhere. With region, the user selects an area
+// instead.
+func captureScreen(dir string, region bool) ([]string, error) {
+	var cmd *exec.Cmd
+	var files []string
+	switch runtime.GOOS {
+	case "darwin":
+		args := []string{"-x"}
+		if region {
+			args = append(args, "-i")
+			files = []string{filepath.Join(dir, "screen.png")}
+		} else {
+			for i := range maxDisplays {
+				files = append(files, filepath.Join(dir, fmt.Sprintf("screen-%d.png", i+1)))
+			}
+		}
+		cmd = exec.Command("screencapture", append(args, files...)...)
+	case "windows":
+		if region {
+			return nil, errors.New("--screen-region is not supported on Windows; use --screen")
+		}
+		files = []string{filepath.Join(dir, "screen.png")}
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", windowsScreenshotScript, files[0])
+	default:
+		files = []string{filepath.Join(dir, "screen.png")}
+		wayland := os.Getenv("WAYLAND_DISPLAY") != ""
+		var names []string
+		for _, tool := range linuxScreenshotTools {
+			if tool.wayland && !wayland {
+				continue
+			}
+			names = append(names, tool.name)
+			if _, err := exec.LookPath(tool.name); err != nil {
+				continue
+			}
+			args, err := tool.args(files[0], region)
+			if err != nil {
+				return nil, err
+			}
+			cmd = exec.Command(tool.name, args...)
+			break
+		}
+		if cmd == nil {
+			return nil, fmt.Errorf("No screenshot tool found; install one of %v", strings.Join(names, ", "))
+		}
+	}
+
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("screenshot failed: %w", err)
+	}
+
+	var written []string
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			written = append(written, file)
+		}
+	}
+	if len(written) == 0 {
+		return nil, errors.New("No screenshot was taken (selection cancelled?)")
+	}
+	return written, nil
+}
+
+// scanScreen captures the screen (or a selected region) and decodes the first
+// otpauth QR code found on it. It returns the account with the screenshot it
+// came from, which is deleted afterwards along with the others.
+func scanScreen(region bool, hints map[gozxing.DecodeHintType]interface{}) (account, string, []byte, error) {
+	dir, err := os.MkdirTemp("", "totp-screen-")
+	if err != nil {
+		return account{}, "", nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	files, err := captureScreen(dir, region)
+	if err != nil {
+		return account{}, "", nil, err
+	}
+	// A QR code that is not an otpauth URI says more than a display without
+	// any.
+	var qrErr error
+	for _, file := range files {
+		a, data, err := scanSource(file, hints, false)
+		if err == nil {
+			return a, file, data, nil
+		}
+		if errors.Is(err, errNotOTP) || errors.Is(err, ErrInvalidSecret) {
+			qrErr = err
+		}
+	}
+	if qrErr != nil {
+		return account{}, "", nil, qrErr
+	}
+	return account{}, "", nil, errors.New("No QR code found on screen; make sure it is fully visible and not too small")
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	defaultNTPServer = "pool.ntp.org"
+
+	// ntpTimeout bounds the whole query so --check-time can never hold up
+	// a code for long.
+	ntpTimeout = 2 * time.Second
+
+	// clockSkewThreshold is the skew above which a warning is printed. Most
+	// services accept one step either side, so anything past a few seconds
+	// already eats into that margin.
+	clockSkewThreshold = 5 * time.Second
+
+	// ntpEpochOffset is the number of seconds between the NTP epoch (1900)
+	// and the Unix epoch (1970).
+	ntpEpochOffset = 2208988800
+)
+
+// ntpTime converts a 64-bit NTP timestamp to a time.Time.
+func ntpTime(b []byte) time.Time {
+	secs := int64(binary.BigEndian.Uint32(b[0:4])) - ntpEpochOffset
+	frac := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(secs, frac*int64(time.Second)>>32)
+}
+
+// clockOffset asks server for the time with a single SNTP (RFC 4330) query
+// and returns how far the local clock is behind it, corrected for the round
+// trip. A positive offset means the local clock is slow.
+func clockOffset(server string) (time.Duration, error) {
+	deadline := time.Now().Add(ntpTimeout)
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.Dial("udp", net.JoinHostPort(server, "123"))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return 0, err
+	}
+
+	req := make([]byte, 48)
+	req[0] = 0x23 // LI 0, version 4, mode 3 (client)
+
+	sent := time.Now()
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	resp := make([]byte, 48)
+	n, err := conn.Read(resp)
+	received := time.Now()
+	if err != nil {
+		return 0, err
+	}
+	if n < 48 {
+		return 0, errors.New("short NTP response")
+	}
+	if mode := resp[0] & 0x7; mode != 4 {
+		return 0, fmt.Errorf("unexpected NTP mode %d", mode)
+	}
+	if stratum := resp[1]; stratum == 0 || stratum > 15 {
+		return 0, fmt.Errorf("NTP server is unsynchronized (stratum %d)", stratum)
+	}
+
+	serverReceived := ntpTime(resp[32:40])
+	serverSent := ntpTime(resp[40:48])
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+// warnClockSkew compares the local clock with server and prints a warning to
+// stderr when they disagree by more than clockSkewThreshold. Failures to
+// reach the server are reported but never fatal.
+func warnClockSkew(server string) {
+	offset, err := clockOffset(server)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not check the clock against %v: %v\n", server, err)
+		return
+	}
+	if offset.Abs() <= clockSkewThreshold {
+		return
+	}
+	direction := "behind"
+	if offset < 0 {
+		direction = "ahead of"
+	}
+	fmt.Fprintf(os.Stderr, "Warning: the system clock is %v %v %v; codes may be rejected.\n",
+		offset.Abs().Round(time.Second), direction, server)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// collectionStats is the report printed by `totp stats`. The counts by
+// parameter cover every readable entry, legacy ones with the defaults they
+// are upgraded to.
+type collectionStats struct {
+	Total      int            `json:"total"`
+	Types      map[string]int `json:"types"`
+	Protected  int            `json:"protected"`
+	Legacy     []string       `json:"legacy"`     // entries `totp migrate` would upgrade
+	Missing    []string       `json:"missing"`    // indexed names with no keyring entry, as `totp prune` would remove
+	Unreadable []string       `json:"unreadable"` // entries whose keyring value could not be read
+	Issuers    map[string]int `json:"issuers"`    // "" counts entries without an issuer
+	Algorithms map[string]int `json:"algorithms"`
+	Digits     map[string]int `json:"digits"`
+	Periods    map[string]int `json:"periods"` // TOTP entries only
+}
+
+// collectStats reads the keyring entry of every name once, without
+// upgrading or unlocking anything. Names without an entry are listed as
+// missing and not counted; entries that cannot be read are listed as
+// unreadable, with the reason on stderr, rather than failing the report.
+func collectStats(names []string) collectionStats {
+	s := collectionStats{
+		Types:      map[string]int{},
+		Legacy:     []string{},
+		Missing:    []string{},
+		Unreadable: []string{},
+		Issuers:    map[string]int{},
+		Algorithms: map[string]int{},
+		Digits:     map[string]int{},
+		Periods:    map[string]int{},
+	}
+	for _, name := range names {
+		value, err := keyringGet(name)
+		if errors.Is(err, keyring.ErrNotFound) {
+			s.Missing = append(s.Missing, name)
+			continue
+		}
+		s.Total++
+		var a account
+		if err == nil {
+			a, err = decodeAccount(value)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v: %v\n", name, err)
+			s.Unreadable = append(s.Unreadable, name)
+			continue
+		}
+		if upgradeAccount(&a) {
+			s.Legacy = append(s.Legacy, name)
+		}
+
+		typ := accountTypeTOTP
+		if a.Type == accountTypeHOTP {
+			typ = accountTypeHOTP
+		}
+		s.Types[typ]++
+		if a.Protected != nil {
+			s.Protected++
+		}
+		s.Issuers[a.Issuer]++
+		s.Algorithms[a.Algorithm]++
+		s.Digits[strconv.Itoa(a.Digits)]++
+		if typ == accountTypeTOTP {
+			s.Periods[strconv.Itoa(a.Period)+"s"]++
+		}
+	}
+	return s
+}
+
+// writeReport prints s as a human-readable report.
+func (s collectionStats) writeReport(out io.Writer) error {
+	w := newTableWriter(out, 2)
+	fmt.Fprintf(w, "Entries:\t%d (%d TOTP, %d HOTP)\n", s.Total, s.Types[accountTypeTOTP], s.Types[accountTypeHOTP])
+	fmt.Fprintf(w, "Protected:\t%d\n", s.Protected)
+	fmt.Fprintf(w, "Legacy:\t%d%v\n", len(s.Legacy), namesHint(s.Legacy, `; run "totp migrate"`))
+	if len(s.Missing) != 0 {
+		fmt.Fprintf(w, "Missing:\t%d%v\n", len(s.Missing), namesHint(s.Missing, `; run "totp prune"`))
+	}
+	if len(s.Unreadable) != 0 {
+		fmt.Fprintf(w, "Unreadable:\t%d%v\n", len(s.Unreadable), namesHint(s.Unreadable, ""))
+	}
+
+	sections := []struct {
+		title  string
+		counts map[string]int
+	}{
+		{"By issuer", s.Issuers},
+		{"By algorithm", s.Algorithms},
+		{"By digits", s.Digits},
+		{"By period", s.Periods},
+	}
+	for _, section := range sections {
+		if len(section.counts) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%v:\n", section.title)
+		for _, key := range sortedByCount(section.counts) {
+			label := key
+			if label == "" {
+				label = "(none)"
+			}
+			fmt.Fprintf(w, "  %v\t%d\n", label, section.counts[key])
+		}
+	}
+	return w.Flush()
+}
+
+// namesHint returns " (a, b)" followed by suffix for a non-empty list of
+// names, and "" otherwise.
+func namesHint(names []string, suffix string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(names, ", ") + ")" + suffix
+}
+
+// sortedByCount returns the keys of counts, most frequent first and then in
+// alphabetical order.
+func sortedByCount(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
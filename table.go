@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// tableWriter aligns tab-separated columns like text/tabwriter, but measures
+// cells by their display width: East Asian wide characters take two columns
+// and combining marks none, so internationalized issuers and account labels
+// line up. The last cell of each line is not padded.
+type tableWriter struct {
+	out     io.Writer
+	padding int
+	buf     bytes.Buffer
+}
+
+func newTableWriter(out io.Writer, padding int) *tableWriter {
+	return &tableWriter{out: out, padding: padding}
+}
+
+// Write buffers p until Flush.
+func (t *tableWriter) Write(p []byte) (int, error) {
+	return t.buf.Write(p)
+}
+
+// Flush writes the buffered lines with every column padded to its widest
+// cell.
+func (t *tableWriter) Flush() error {
+	text := strings.TrimSuffix(t.buf.String(), "\n")
+	t.buf.Reset()
+	if text == "" {
+		return nil
+	}
+
+	var rows [][]string
+	var widths []int
+	for _, line := range strings.Split(text, "\n") {
+		cells := strings.Split(line, "\t")
+		for i, cell := range cells[:len(cells)-1] {
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], runewidth.StringWidth(cell))
+		}
+		rows = append(rows, cells)
+	}
+
+	var sb strings.Builder
+	for _, cells := range rows {
+		for i, cell := range cells {
+			sb.WriteString(cell)
+			if i < len(cells)-1 {
+				sb.WriteString(strings.Repeat(" ", widths[i]-runewidth.StringWidth(cell)+t.padding))
+			}
+		}
+		sb.WriteString("\n")
+	}
+	_, err := io.WriteString(t.out, sb.String())
+	return err
+}
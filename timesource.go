@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// An entry's time source is the clock its codes are computed against, for
+// services whose servers are known to drift: an NTP server ("time.example.com"
+// or "ntp://time.example.com") or an http(s) URL whose Date header is used.
+
+// checkTimeSource validates a time source given on the command line.
+func checkTimeSource(src string) error {
+	if host, ok := strings.CutPrefix(src, "ntp://"); ok {
+		src = host
+	}
+	if strings.Contains(src, "://") {
+		u, err := url.Parse(src)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid time source %q (expected an NTP server or an http(s) URL)", src)
+		}
+		return nil
+	}
+	if src == "" || strings.ContainsAny(src, "/ ") {
+		return fmt.Errorf("invalid time source %q (expected an NTP server or an http(s) URL)", src)
+	}
+	return nil
+}
+
+// httpClockOffset returns how far the local clock is behind the Date header
+// of a HEAD request to rawURL, corrected for the round trip. The header only
+// has whole seconds, which is plenty for 30-second steps.
+func httpClockOffset(rawURL string) (time.Duration, error) {
+	client := http.Client{Timeout: ntpTimeout}
+	sent := time.Now()
+	resp, err := client.Head(rawURL)
+	if err != nil {
+		return 0, err
+	}
+	received := time.Now()
+	resp.Body.Close()
+
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, errors.New("no usable Date header in the response")
+	}
+	// The header truncates to the second, so aim at the middle of it.
+	return date.Add(time.Second / 2).Sub(sent.Add(received.Sub(sent) / 2)), nil
+}
+
+// timeSourceOffsets caches the offset of each time source for the rest of
+// the run, so watching or listing many entries queries each source once.
+// Sources that could not be reached are cached as 0, the local clock.
+var timeSourceOffsets = map[string]time.Duration{}
+
+// timeSourceOffset returns how far the local clock is behind src.
+func timeSourceOffset(src string) (time.Duration, error) {
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return httpClockOffset(src)
+	}
+	return clockOffset(strings.TrimPrefix(src, "ntp://"))
+}
+
+// accountTime returns now as told by the time source of a, or now itself if
+// a has none. If the source cannot be reached, a warning is printed once and
+// the local clock is used.
+func accountTime(a account, now time.Time) time.Time {
+	if a.TimeSource == "" {
+		return now
+	}
+	offset, ok := timeSourceOffsets[a.TimeSource]
+	if !ok {
+		var err error
+		if offset, err = timeSourceOffset(a.TimeSource); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not reach time source %v: %v; using the local clock.\n", a.TimeSource, err)
+		}
+		timeSourceOffsets[a.TimeSource] = offset
+	}
+	return now.Add(offset)
+}
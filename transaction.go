@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// importTxn makes a bulk import all or nothing (--atomic): it records the
+// entries added and the index as it was before, so that a failure part way
+// through can put both back.
+type importTxn struct {
+	index indexFile
+	added []string
+}
+
+// beginImport snapshots the index for a later rollback.
+func beginImport() (*importTxn, error) {
+	idx, err := readIndex()
+	if err != nil {
+		return nil, err
+	}
+	return &importTxn{index: idx}, nil
+}
+
+// add stores a under name like addItem, recording it for rollback. The name
+// is recorded before anything is written, since the keyring write may
+// succeed and the index write fail.
+func (t *importTxn) add(name string, a account) error {
+	t.added = append(t.added, name)
+	return addItem(name, a)
+}
+
+// rollback deletes the entries added so far and restores the index, and
+// reports what it undid on stderr. Names are only ever added to the
+// transaction after checking they were free, so deleting them loses nothing.
+func (t *importTxn) rollback() error {
+	var errs []error
+	var removed []string
+	for _, name := range t.added {
+		if err := keyringDelete(name); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			errs = append(errs, fmt.Errorf("%v: %w", name, err))
+			continue
+		}
+		removed = append(removed, name)
+	}
+	if err := updateIndex(func(idx *indexFile) error {
+		*idx = t.index
+		return nil
+	}); err != nil {
+		errs = append(errs, fmt.Errorf("restoring the index: %w", err))
+	}
+
+	if len(removed) == 0 {
+		fmt.Fprintln(os.Stderr, "Rolled back: nothing had been added.")
+	} else {
+		fmt.Fprintf(os.Stderr, "Rolled back: removed %d entries (%v) and restored the index.\n", len(removed), strings.Join(removed, ", "))
+	}
+	if len(errs) != 0 {
+		return fmt.Errorf("rollback incomplete: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// fail rolls t back, if there is a transaction, and returns err along with
+// any rollback error.
+func (t *importTxn) fail(err error) error {
+	if t == nil {
+		return err
+	}
+	if rbErr := t.rollback(); rbErr != nil {
+		return errors.Join(err, rbErr)
+	}
+	return err
+}
+
+// pendingImport is one entry a bulk import is to store: label says where it
+// came from in messages (an image file, a row of an export), and err why it
+// could not be read, if so.
+type pendingImport struct {
+	label string
+	name  string
+	a     account
+	err   error
+}
+
+// storeImports stores the entries of a bulk import under their names and
+// prints the outcome of each and a summary; noun ("images", "entries") names
+// them in the final error. Names that are already taken are asked about one
+// by one once the others are stored; an empty answer skips that entry.
+//
+// With atomic, nothing is stored if any entry could not be read, and a
+// failure part way through rolls back the entries already added.
+func storeImports(items []pendingImport, atomic bool, noun string) error {
+	add := addItem
+	var txn *importTxn
+	if atomic {
+		for _, it := range items {
+			if it.err != nil {
+				return fmt.Errorf("%v: %w; nothing was imported", it.label, it.err)
+			}
+		}
+		var err error
+		if txn, err = beginImport(); err != nil {
+			return err
+		}
+		add = txn.add
+	}
+
+	status := make([]string, len(items))
+	var imported, skipped, failed int
+	store := func(i int, name string) error {
+		if err := add(name, items[i].a); err != nil {
+			failed++
+			status[i] = fmt.Sprintf("failed: %v", err)
+			return fmt.Errorf("%v: %w", items[i].label, err)
+		}
+		imported++
+		status[i] = fmt.Sprintf("imported as \"%v\"", name)
+		return nil
+	}
+
+	var collisions []int
+	for i, it := range items {
+		if it.err != nil {
+			failed++
+			status[i] = fmt.Sprintf("failed: %v", it.err)
+			continue
+		}
+		exists, err := nameExists(it.name)
+		if err != nil {
+			return txn.fail(err)
+		}
+		if exists {
+			collisions = append(collisions, i)
+			continue
+		}
+		if err := store(i, it.name); err != nil && txn != nil {
+			return txn.fail(err)
+		}
+	}
+	for _, i := range collisions {
+		name, err := promptImportName(items[i].label, items[i].name)
+		if err != nil {
+			return txn.fail(err)
+		}
+		if name == "" {
+			skipped++
+			status[i] = "skipped"
+			continue
+		}
+		if err := store(i, name); err != nil && txn != nil {
+			return txn.fail(err)
+		}
+	}
+
+	for i, it := range items {
+		if !quietInfo() || strings.HasPrefix(status[i], "failed") {
+			fmt.Printf("%v: %v\n", it.label, status[i])
+		}
+	}
+	infof("Imported %d, skipped %d, failed %d.\n", imported, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("Failed to import %d of %d %v", failed, len(items), noun)
+	}
+	return nil
+}
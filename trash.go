@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// trashTTL is how long `totp undo` can take back the last delete, rename or
+// edit. After that the trash is wiped.
+const trashTTL = 5 * time.Minute
+
+const (
+	trashDelete   = "delete"
+	trashRename   = "rename"
+	trashEdit     = "edit"
+	trashRotation = "rotation" // confirm-rotation
+)
+
+// trashFile is the last destructive operation, kept next to the index for
+// trashTTL so that it can be undone. The keyring values it holds are
+// encrypted with AES-256-GCM under a random key that is kept in the keyring
+// (service "<service>-trash") and deleted with the file, so the file alone
+// reveals no secret.
+type trashFile struct {
+	Op    string      `json:"op"`
+	Time  int64       `json:"time"` // Unix time of the operation
+	Items []trashItem `json:"items"`
+}
+
+// trashItem is one entry the operation touched, as it was before.
+type trashItem struct {
+	Name    string     `json:"name"`
+	NewName string     `json:"new_name,omitempty"` // renames only
+	Entry   indexEntry `json:"entry"`
+	Nonce   []byte     `json:"nonce,omitempty"`
+	Value   []byte     `json:"value,omitempty"` // sealed keyring value; not kept for renames
+}
+
+func trashPath() (string, error) {
+	path, err := indexFilePath()
+	if err != nil {
+		return "", err
+	}
+	return path + ".trash", nil
+}
+
+func trashService() string {
+	return serviceName + "-trash"
+}
+
+const trashKeyUser = "key"
+
+// trashItemsFor reads the keyring value and index metadata of each name
+// about to be deleted or changed. Names that cannot be read are left out.
+func trashItemsFor(names []string) ([]trashItem, []string) {
+	idx, _ := readIndex()
+	var items []trashItem
+	var values []string
+	for _, name := range names {
+		value, err := keyringGet(name)
+		if err != nil {
+			continue
+		}
+		items = append(items, trashItem{Name: name, Entry: idx.Entries[name]})
+		values = append(values, value)
+	}
+	return items, values
+}
+
+// saveTrash replaces the trash with op on items, sealing values (one per
+// item, or none for renames) under a fresh key, and tells the user how long
+// they have to undo it. Failing to keep the trash never stops the operation
+// itself: it is reported as a warning.
+func saveTrash(op string, items []trashItem, values []string) {
+	if readOnly || len(items) == 0 {
+		return
+	}
+	if err := writeTrash(op, items, values); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not keep the %v for undo: %v\n", op, err)
+		return
+	}
+	infof("Run \"totp undo\" within %d minutes to take it back.\n", int(trashTTL.Minutes()))
+}
+
+func writeTrash(op string, items []trashItem, values []string) error {
+	path, err := trashPath()
+	if err != nil {
+		return err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	defer wipe(key)
+
+	if len(values) != 0 {
+		aead, err := trashCipher(key)
+		if err != nil {
+			return err
+		}
+		for i := range items {
+			items[i].Nonce = make([]byte, aead.NonceSize())
+			if _, err := rand.Read(items[i].Nonce); err != nil {
+				return err
+			}
+			items[i].Value = aead.Seal(nil, items[i].Nonce, []byte(values[i]), []byte(items[i].Name))
+		}
+		if err := withRetry(func() error {
+			return store.Set(trashService(), trashKeyUser, base64.StdEncoding.EncodeToString(key))
+		}); err != nil {
+			return err
+		}
+	} else {
+		// Nothing sealed: the key of a previous trash is no longer needed.
+		_ = store.Delete(trashService(), trashKeyUser)
+	}
+
+	b, err := json.MarshalIndent(trashFile{Op: op, Time: time.Now().Unix(), Items: items}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o600)
+}
+
+func trashCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// readTrash returns the trash if there is one that has not expired. An
+// expired trash is wiped.
+func readTrash() (trashFile, bool, error) {
+	path, err := trashPath()
+	if err != nil {
+		return trashFile{}, false, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return trashFile{}, false, nil
+		}
+		return trashFile{}, false, err
+	}
+	var t trashFile
+	if err := json.Unmarshal(b, &t); err != nil {
+		wipeTrash()
+		return trashFile{}, false, nil
+	}
+	if time.Since(time.Unix(t.Time, 0)) > trashTTL {
+		wipeTrash()
+		return trashFile{}, false, nil
+	}
+	return t, true, nil
+}
+
+// openTrashValues decrypts the keyring values kept in t, one per item.
+func openTrashValues(t trashFile) ([]string, error) {
+	var encoded string
+	if err := withRetry(func() error {
+		var err error
+		encoded, err = store.Get(trashService(), trashKeyUser)
+		return err
+	}); err != nil {
+		return nil, fmt.Errorf("reading the trash key from the keyring: %w", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("trash key is corrupt: %w", err)
+	}
+	defer wipe(key)
+	aead, err := trashCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]string, len(t.Items))
+	for i, item := range t.Items {
+		plaintext, err := aead.Open(nil, item.Nonce, item.Value, []byte(item.Name))
+		if err != nil {
+			return nil, fmt.Errorf("trash entry \"%v\" cannot be decrypted", item.Name)
+		}
+		values[i] = string(plaintext)
+		wipe(plaintext)
+	}
+	return values, nil
+}
+
+// wipeTrash overwrites the trash file before removing it and deletes its key
+// from the keyring. Errors are ignored: there is nothing left to protect if
+// the file is gone, and nothing to decrypt if the key is.
+func wipeTrash() {
+	if readOnly {
+		return
+	}
+	path, err := trashPath()
+	if err != nil {
+		return
+	}
+	if info, err := os.Stat(path); err == nil {
+		if f, err := os.OpenFile(path, os.O_WRONLY, 0); err == nil {
+			f.Write(make([]byte, info.Size()))
+			f.Sync()
+			f.Close()
+		}
+		os.Remove(path)
+	}
+	_ = store.Delete(trashService(), trashKeyUser)
+}
+
+// expireTrash wipes the trash once it is older than trashTTL. It runs before
+// every command, so secrets do not linger in the trash just because undo is
+// never run.
+func expireTrash() {
+	path, err := trashPath()
+	if err != nil {
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		return
+	}
+	_, _, _ = readTrash()
+}
+
+// undoTrash takes back the operation in t and wipes the trash, returning
+// the names restored. Entries that cannot be restored, e.g. because their
+// name has been taken since, are reported and the rest restored anyway.
+func undoTrash(t trashFile) ([]string, error) {
+	var restored []string
+	var errs []error
+	switch t.Op {
+	case trashRename:
+		pairs := make([]renamePair, len(t.Items))
+		for i, item := range t.Items {
+			pairs[i] = renamePair{From: item.NewName, To: item.Name}
+		}
+		if err := renameItems(pairs); err != nil {
+			return nil, err
+		}
+		for _, item := range t.Items {
+			restored = append(restored, item.Name)
+		}
+	case trashDelete, trashEdit, trashRotation:
+		values, err := openTrashValues(t)
+		if err != nil {
+			return nil, err
+		}
+		for i, item := range t.Items {
+			if t.Op == trashDelete {
+				if _, err := keyringGet(item.Name); err == nil {
+					errs = append(errs, fmt.Errorf("%w: \"%v\" (not restored)", ErrNameExists, item.Name))
+					continue
+				}
+			}
+			if err := keyringSet(item.Name, values[i]); err != nil {
+				errs = append(errs, fmt.Errorf("restoring \"%v\": %w", item.Name, err))
+				continue
+			}
+			if err := restoreIndexEntry(item.Name, item.Entry); err != nil {
+				errs = append(errs, fmt.Errorf("restoring \"%v\" in the index: %w", item.Name, err))
+				continue
+			}
+			restored = append(restored, item.Name)
+		}
+	default:
+		return nil, fmt.Errorf("unknown operation %q in the trash", t.Op)
+	}
+	wipeTrash()
+	return restored, errors.Join(errs...)
+}
+
+// restoreIndexEntry puts name and its metadata back in the index as they
+// were.
+func restoreIndexEntry(name string, entry indexEntry) error {
+	return updateIndex(func(idx *indexFile) error {
+		found := false
+		for _, n := range idx.Names {
+			if n == name {
+				found = true
+			}
+		}
+		if !found {
+			idx.Names = append(idx.Names, name)
+		}
+		if idx.Entries == nil {
+			idx.Entries = map[string]indexEntry{}
+		}
+		idx.Entries[name] = entry
+		return nil
+	})
+}
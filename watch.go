@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/atotto/clipboard"
+)
+
+// codeCache remembers the TOTP code of each account for its current time
+// step, so loops that redraw every second only compute the HMAC once per
+// step. An account's entry is replaced when its step rolls over.
+type codeCache struct {
+	codes map[string]codeCacheEntry
+}
+
+type codeCacheEntry struct {
+	step uint64
+	code string
+}
+
+func newCodeCache() *codeCache {
+	return &codeCache{codes: map[string]codeCacheEntry{}}
+}
+
+// code returns the code of the account stored under name for t, computing it
+// only if the cached one belongs to another time step.
+func (c *codeCache) code(name string, a account, t time.Time) (string, error) {
+	if a.Period <= 0 || t.Unix() < a.T0 {
+		return a.code(t)
+	}
+	step := a.step(t)
+	if e, ok := c.codes[name]; ok && e.step == step {
+		return e.code, nil
+	}
+
+	code, err := a.code(t)
+	if err != nil {
+		return "", err
+	}
+	c.codes[name] = codeCacheEntry{step: step, code: code}
+	return code, nil
+}
+
+// clipboardOwner copies codes to the clipboard and remembers the last one,
+// so it can clear the clipboard later without wiping anything the user
+// copied in the meantime.
+type clipboardOwner struct {
+	last string
+}
+
+func (c *clipboardOwner) copy(code string) error {
+	if err := clipboard.WriteAll(code); err != nil {
+		return fmt.Errorf("copy failed: %w", err)
+	}
+	c.last = code
+	return nil
+}
+
+// clear empties the clipboard if it still holds the last code copied.
+func (c *clipboardOwner) clear() {
+	if c.last == "" {
+		return
+	}
+	if current, err := clipboard.ReadAll(); err == nil && current == c.last {
+		_ = clipboard.WriteAll("")
+	}
+}
+
+// watchWarnSeconds is when the watch countdown turns red.
+const watchWarnSeconds = 5
+
+// Boundaries --time-step-boundary-wait can align watch output to.
+const (
+	watchAlignSecond = "second"
+	watchAlignStep   = "step"
+)
+
+// boundaryWait returns how long to sleep from now until the next whole second
+// or, with watchAlignStep, the start of a's next time step. It returns 0 for
+// an empty align.
+func boundaryWait(a account, now time.Time, align string) (time.Duration, error) {
+	switch align {
+	case "":
+		return 0, nil
+	case watchAlignSecond:
+		if now.Nanosecond() == 0 {
+			return 0, nil
+		}
+		return time.Second - time.Duration(now.Nanosecond()), nil
+	case watchAlignStep:
+		if now.Unix() < a.T0 {
+			return time.Unix(a.T0, 0).Sub(now), nil
+		}
+		return time.Unix(a.stepStart(now)+int64(a.Period), 0).Sub(now), nil
+	default:
+		return 0, fmt.Errorf("unknown boundary %q (expected %v or %v)", align, watchAlignSecond, watchAlignStep)
+	}
+}
+
+// watchOptions are the get flags that change how watchCode behaves.
+type watchOptions struct {
+	// copy copies every new code to the clipboard; with clearOnExit as well,
+	// the clipboard is cleared on the way out (including on Ctrl-C, SIGTERM
+	// and SIGHUP) unless something else was copied since.
+	copy, clearOnExit bool
+
+	// align, if not empty, delays the first line until the next whole second
+	// or time step (see boundaryWait), so the countdown ticks evenly.
+	align string
+
+	// exitAtExpiry stops watching the moment the first code shown expires,
+	// so whoever runs it knows to fetch the next one.
+	exitAtExpiry bool
+
+	// mask prints every digit as "*"; copied codes are still real.
+	mask bool
+}
+
+// watchCode prints the code of the TOTP account a until interrupted. On a
+// terminal the line is redrawn every second with a countdown, turning red
+// for the last few seconds if color is on; otherwise each new code is printed
+// on its own line as it comes up.
+func watchCode(name string, a account, opts watchOptions) error {
+	interactive := isTerminal(os.Stdout)
+	color := useColor(os.Stdout)
+	cache := newCodeCache()
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(interrupt)
+
+	var owner clipboardOwner
+	if opts.clearOnExit {
+		defer owner.clear()
+	}
+
+	wait, err := boundaryWait(a, accountTime(a, time.Now()), opts.align)
+	if err != nil {
+		return err
+	}
+	if wait > 0 {
+		select {
+		case <-time.After(wait):
+		case <-interrupt:
+			return nil
+		}
+	}
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	// A nil channel never fires, so without exitAtExpiry only the ticker and
+	// interrupts wake the loop.
+	var expired <-chan time.Time
+	if opts.exitAtExpiry {
+		now := accountTime(a, time.Now())
+		timer := time.NewTimer(time.Unix(a.stepStart(now)+int64(a.Period), 0).Sub(now))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var last string
+	for {
+		now := accountTime(a, time.Now())
+		code, err := cache.code(name, a, now)
+		if err != nil {
+			return err
+		}
+		if opts.copy && code != last {
+			if err := owner.copy(code); err != nil {
+				return err
+			}
+		}
+		shown := code
+		if opts.mask {
+			shown = maskCode(code)
+		}
+		if interactive {
+			expiresIn := a.expiresIn(now)
+			countdown := fmt.Sprintf("(%2ds)", expiresIn)
+			fmt.Printf("\r%v %v", shown, red(countdown, color && expiresIn <= watchWarnSeconds))
+		} else if code != last {
+			fmt.Println(shown)
+		}
+		last = code
+
+		select {
+		case <-ticker.C:
+		case <-expired:
+			if interactive {
+				fmt.Println()
+			}
+			return nil
+		case <-interrupt:
+			if interactive {
+				fmt.Println()
+			}
+			return nil
+		}
+	}
+}
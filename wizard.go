@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// promptValue asks for a value on stderr, showing def in brackets and
+// returning it for an empty answer. Answers that check rejects are reported
+// and asked again.
+func promptValue(label, def string, check func(string) error) (string, error) {
+	prompt := label + ": "
+	if def != "" {
+		prompt = fmt.Sprintf("%v [%v]: ", label, def)
+	}
+
+	for {
+		fmt.Fprint(os.Stderr, prompt)
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+
+		value := strings.TrimSpace(line)
+		if value == "" {
+			value = def
+		}
+		if err := check(value); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		return value, nil
+	}
+}
+
+// addWizard walks through the parameters of a new entry one by one, for
+// `add --interactive`. The fields of a and name (if any) are offered as
+// defaults; the returned name is not registered yet.
+func addWizard(name string, a account) (string, account, error) {
+	name, err := promptValue("Name", name, func(v string) error {
+		if v == "" {
+			return errors.New("A name is required")
+		}
+		return nil
+	})
+	if err != nil {
+		return "", account{}, err
+	}
+	if name, err = promptNewName(name); err != nil {
+		return "", account{}, err
+	}
+
+	for {
+		b, err := readPassphrase("Secret (input hidden): ")
+		if err != nil {
+			return "", account{}, err
+		}
+		secret, err := normalizeAndValidateSecret(string(b))
+		wipe(b)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		a.Secret = secret
+		break
+	}
+
+	digits, err := promptValue("Digits", strconv.Itoa(a.Digits), func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid digits: %q", v)
+		}
+		return checkParams(defaultAlgorithm, n, defaultPeriod)
+	})
+	if err != nil {
+		return "", account{}, err
+	}
+	a.Digits, _ = strconv.Atoi(digits)
+
+	period, err := promptValue("Period in seconds", strconv.Itoa(a.Period), func(v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid period: %q", v)
+		}
+		return checkParams(defaultAlgorithm, defaultDigits, n)
+	})
+	if err != nil {
+		return "", account{}, err
+	}
+	a.Period, _ = strconv.Atoi(period)
+
+	algorithm, err := promptValue("Algorithm (sha1, sha256, sha512)", strings.ToLower(a.Algorithm), func(v string) error {
+		_, err := hashFunc(strings.ToUpper(v))
+		return err
+	})
+	if err != nil {
+		return "", account{}, err
+	}
+	a.Algorithm = strings.ToUpper(algorithm)
+
+	if a.Issuer, err = promptValue("Issuer", a.Issuer, func(string) error { return nil }); err != nil {
+		return "", account{}, err
+	}
+	return name, a, nil
+}